@@ -0,0 +1,236 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	applyValidatorName            = "applyValidator"
+	defaultValidatorReportLimit   = 100
+	validationLevelOff            = "off"
+	validationLevelModerate       = "moderate"
+	validationLevelStrict         = "strict"
+	validationActionWarn          = "warn"
+	validationActionError         = "error"
+	defaultValidatorLevel         = validationLevelStrict
+	defaultValidatorAction        = validationActionWarn
+	validatorSchemaOperator       = "$jsonSchema"
+	validatorCollectionOptionsKey = "options"
+
+	// validatorSchemaFileEnvVar is the path to a file with the extended JSON
+	// schema to use as the collection's $jsonSchema validator.
+	validatorSchemaFileEnvVar = "VALIDATOR_SCHEMA_FILE"
+	// validatorLevelEnvVar is the validation level, 'off', 'moderate' or
+	// 'strict'. It defaults to 'strict'.
+	validatorLevelEnvVar = "VALIDATOR_LEVEL"
+	// validatorActionEnvVar is the validation action, 'warn' or 'error'. It
+	// defaults to 'warn'.
+	validatorActionEnvVar = "VALIDATOR_ACTION"
+	// validatorReportLimitEnvVar is the most violating documents to report
+	// by ID. Every violating document is counted regardless.
+	validatorReportLimitEnvVar = "VALIDATOR_REPORT_LIMIT"
+	// validatorDryRunEnvVar, if true, only reports the documents that
+	// violate the schema without applying the validator.
+	validatorDryRunEnvVar = "VALIDATOR_DRY_RUN"
+)
+
+func init() {
+	Registry.registerMigration(applyValidatorName, newApplyValidator, withRisk(RiskWrite))
+}
+
+// applyValidator applies a $jsonSchema validator to a collection after
+// reporting the existing documents that violate it.
+type applyValidator struct {
+	database    string
+	collection  string
+	batchSize   int
+	schema      bson.D
+	level       string
+	action      string
+	reportLimit int
+	dryRun      bool
+}
+
+func newApplyValidator(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+	catcher.NewWhen(opts.Collection == "", "collection name not specified")
+
+	a := &applyValidator{
+		database:    opts.Database,
+		collection:  opts.Collection,
+		batchSize:   opts.BatchSize,
+		level:       defaultValidatorLevel,
+		action:      defaultValidatorAction,
+		reportLimit: defaultValidatorReportLimit,
+	}
+
+	schemaFile := os.Getenv(validatorSchemaFileEnvVar)
+	if schemaFile == "" {
+		catcher.Errorf("expected environment variable '%s' was not specified", validatorSchemaFileEnvVar)
+	} else {
+		schema, err := readValidatorSchema(schemaFile)
+		catcher.Wrapf(err, "reading schema file '%s'", schemaFile)
+		a.schema = schema
+	}
+
+	if level := os.Getenv(validatorLevelEnvVar); level != "" {
+		a.level = level
+	}
+	catcher.ErrorfWhen(a.level != validationLevelOff && a.level != validationLevelModerate && a.level != validationLevelStrict,
+		"'%s' must be '%s', '%s' or '%s'", validatorLevelEnvVar, validationLevelOff, validationLevelModerate, validationLevelStrict)
+	if action := os.Getenv(validatorActionEnvVar); action != "" {
+		a.action = action
+	}
+	catcher.ErrorfWhen(a.action != validationActionWarn && a.action != validationActionError,
+		"'%s' must be '%s' or '%s'", validatorActionEnvVar, validationActionWarn, validationActionError)
+
+	if limitStr := os.Getenv(validatorReportLimitEnvVar); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		catcher.Wrapf(err, "parsing '%s'", validatorReportLimitEnvVar)
+		catcher.ErrorfWhen(err == nil && limit < 0, "'%s' can't be negative", validatorReportLimitEnvVar)
+		a.reportLimit = limit
+	}
+	if dryRunStr := os.Getenv(validatorDryRunEnvVar); dryRunStr != "" {
+		dryRun, err := strconv.ParseBool(dryRunStr)
+		catcher.Wrapf(err, "parsing '%s'", validatorDryRunEnvVar)
+		a.dryRun = dryRun
+	}
+
+	return a, catcher.Resolve()
+}
+
+// readValidatorSchema reads the extended JSON schema in the file.
+func readValidatorSchema(path string) (bson.D, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schema bson.D
+	if err := bson.UnmarshalExtJSON(data, false, &schema); err != nil {
+		return nil, errors.Wrap(err, "parsing schema")
+	}
+	if len(schema) == 0 {
+		return nil, errors.New("schema is empty")
+	}
+	return schema, nil
+}
+
+// Execute scans the collection for documents that violate the schema and
+// reports them. Unless it's a dry run, it then applies the validator with
+// collMod, which replaces any existing validator, so rerunning the script
+// leaves the collection as it is. It refuses to apply a validator that
+// rejects writes while documents violate the schema, since updates to those
+// documents would start to fail.
+func (a *applyValidator) Execute(ctx context.Context, client *mongo.Client) error {
+	db := client.Database(a.database)
+	violations, err := a.reportViolations(ctx, db.Collection(a.collection))
+	if err != nil {
+		return errors.Wrap(err, "finding documents that violate the schema")
+	}
+	if a.dryRun {
+		grip.Infof("Found %d documents in collection '%s' that violate the schema", violations, a.collection)
+		return nil
+	}
+	if violations > 0 && a.action == validationActionError && a.level != validationLevelOff {
+		return errors.Errorf("refusing to apply validation action '%s' to collection '%s' while %d documents violate the schema; fix them or use action '%s' first", a.action, a.collection, violations, validationActionWarn)
+	}
+
+	existing, level, action, err := getValidator(ctx, db, a.collection)
+	if err != nil {
+		return errors.Wrap(err, "getting existing validator")
+	}
+	if existing != nil {
+		grip.Infof("Replacing existing validator on collection '%s' with validation level '%s' and action '%s'", a.collection, level, action)
+	}
+
+	if err := db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: a.collection},
+		{Key: "validator", Value: bson.M{validatorSchemaOperator: a.schema}},
+		{Key: "validationLevel", Value: a.level},
+		{Key: "validationAction", Value: a.action},
+	}).Err(); err != nil {
+		return errors.Wrapf(err, "applying validator to collection '%s'", a.collection)
+	}
+
+	grip.Infof("Applied validator to collection '%s' with validation level '%s' and action '%s'; %d documents violate the schema", a.collection, a.level, a.action, violations)
+	return nil
+}
+
+// Preflight requires the collection to exist, since collMod can't create it.
+// Applying a validator doesn't modify any documents.
+func (a *applyValidator) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	return &PreflightRequirements{Collections: []string{a.collection}}, nil
+}
+
+// reportViolations logs the IDs of the documents that violate the schema, up
+// to the report limit, and returns the number of them.
+func (a *applyValidator) reportViolations(ctx context.Context, coll *mongo.Collection) (int64, error) {
+	opts := options.Aggregate()
+	if a.batchSize > 0 {
+		opts.SetBatchSize(int32(a.batchSize))
+	}
+	cur, err := coll.Aggregate(ctx, a.violationsPipeline(), opts)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	var count int64
+	for cur.Next(ctx) {
+		count++
+		if count <= int64(a.reportLimit) {
+			grip.Warningf("Document %s in collection '%s' violates the schema", cur.Current.Lookup("_id"), a.collection)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return 0, err
+	}
+	if count > int64(a.reportLimit) {
+		grip.Warningf("%d more documents in collection '%s' violate the schema", count-int64(a.reportLimit), a.collection)
+	}
+	return count, nil
+}
+
+// violationsPipeline returns an aggregation that returns the IDs of the
+// documents that don't match the schema.
+func (a *applyValidator) violationsPipeline() bson.A {
+	return bson.A{
+		bson.M{"$match": bson.M{"$nor": bson.A{bson.M{validatorSchemaOperator: a.schema}}}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$project": bson.M{"_id": 1}},
+	}
+}
+
+// getValidator returns the collection's validator, validation level and
+// validation action.
+func getValidator(ctx context.Context, db *mongo.Database, collection string) (bson.Raw, string, string, error) {
+	cur, err := db.ListCollections(ctx, bson.M{"name": collection})
+	if err != nil {
+		return nil, "", "", errors.Wrap(err, "listing collections")
+	}
+	var collections []bson.Raw
+	if err := cur.All(ctx, &collections); err != nil {
+		return nil, "", "", errors.Wrap(err, "iterating over collections")
+	}
+	if len(collections) == 0 {
+		return nil, "", "", errors.Errorf("collection '%s' not found", collection)
+	}
+
+	opts, ok := collections[0].Lookup(validatorCollectionOptionsKey).DocumentOK()
+	if !ok {
+		return nil, "", "", nil
+	}
+	validator, _ := opts.Lookup("validator").DocumentOK()
+	level, _ := opts.Lookup("validationLevel").StringValueOK()
+	action, _ := opts.Lookup("validationAction").StringValueOK()
+	return validator, level, action, nil
+}
@@ -0,0 +1,89 @@
+package migrations
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var testValidatorSchemaFile = filepath.Join("testdata", applyValidatorName, "tasks", "schema.json")
+
+func TestNewApplyValidator(t *testing.T) {
+	opts := MigrationOptions{Database: "db", Collection: "tasks"}
+
+	t.Run("MissingSchema", func(t *testing.T) {
+		_, err := newApplyValidator(opts)
+		assert.Error(t, err)
+	})
+	t.Run("SchemaFileNotFound", func(t *testing.T) {
+		t.Setenv(validatorSchemaFileEnvVar, filepath.Join(t.TempDir(), "schema.json"))
+		_, err := newApplyValidator(opts)
+		assert.Error(t, err)
+	})
+	t.Run("InvalidAction", func(t *testing.T) {
+		t.Setenv(validatorSchemaFileEnvVar, testValidatorSchemaFile)
+		t.Setenv(validatorActionEnvVar, "reject")
+		_, err := newApplyValidator(opts)
+		assert.Error(t, err)
+	})
+	t.Run("Defaults", func(t *testing.T) {
+		t.Setenv(validatorSchemaFileEnvVar, testValidatorSchemaFile)
+		migration, err := newApplyValidator(opts)
+		require.NoError(t, err)
+		validator := migration.(*applyValidator)
+		assert.Equal(t, validationLevelStrict, validator.level)
+		assert.Equal(t, validationActionWarn, validator.action)
+		assert.NotEmpty(t, validator.schema)
+	})
+}
+
+func TestApplyValidator(t *testing.T) {
+	t.Setenv(validatorSchemaFileEnvVar, testValidatorSchemaFile)
+
+	t.Run("Warn", func(t *testing.T) {
+		// Applying a validator leaves the documents as they are, including
+		// the ones that violate it.
+		db := runGoldenTest(t, applyValidatorName, "tasks", MigrationOptions{Collection: "tasks"})
+
+		validator, level, action, err := getValidator(context.Background(), db, "tasks")
+		require.NoError(t, err)
+		assert.NotNil(t, validator.Lookup(validatorSchemaOperator).Document())
+		assert.Equal(t, validationLevelStrict, level)
+		assert.Equal(t, validationActionWarn, action)
+	})
+	t.Run("ErrorWithViolations", func(t *testing.T) {
+		t.Setenv(validatorActionEnvVar, validationActionError)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client, db := newTestClient(ctx, t)
+		fixture, err := testdata.LoadFixture(ctx, path.Join("testdata", applyValidatorName, "tasks"), db, client)
+		t.Cleanup(func() {
+			assert.NoError(t, fixture.Cleanup(context.Background()))
+		})
+		require.NoError(t, err)
+
+		migration, err := Registry.Migration(applyValidatorName, MigrationOptions{Database: db, Collection: "tasks"})
+		require.NoError(t, err)
+		assert.Error(t, migration.Execute(ctx, client), "should refuse to reject writes while documents violate the schema")
+		validator, _, _, err := getValidator(ctx, client.Database(db), "tasks")
+		require.NoError(t, err)
+		assert.Nil(t, validator, "validator should not be applied")
+
+		_, err = client.Database(db).Collection("tasks").DeleteMany(ctx, bson.M{"_id": bson.M{"$in": bson.A{"t3", "t4", "t5"}}})
+		require.NoError(t, err)
+		require.NoError(t, migration.Execute(ctx, client))
+		_, _, action, err := getValidator(ctx, client.Database(db), "tasks")
+		require.NoError(t, err)
+		assert.Equal(t, validationActionError, action)
+
+		_, err = client.Database(db).Collection("tasks").InsertOne(ctx, bson.M{"_id": "t6", "status": "unknown", "execution": 0})
+		assert.Error(t, err, "validator should reject invalid documents")
+	})
+}
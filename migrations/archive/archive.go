@@ -0,0 +1,211 @@
+// Package archive reads and writes archives of a collection's documents as
+// gzip-compressed JSONL or BSON files with a manifest.
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Format is the encoding of the documents in an archive file.
+type Format string
+
+const (
+	// FormatJSONL files have one canonical extended JSON document per line.
+	FormatJSONL Format = "jsonl"
+	// FormatBSON files are concatenated BSON documents, as written by
+	// mongodump.
+	FormatBSON Format = "bson"
+
+	// ManifestFile is the name of the manifest in an archive directory.
+	ManifestFile = "manifest.json"
+
+	// maxDocumentSize is the largest document MongoDB can store.
+	maxDocumentSize = 16 * 1024 * 1024
+)
+
+// Validate checks that the format is supported.
+func (f Format) Validate() error {
+	switch f {
+	case FormatJSONL, FormatBSON:
+		return nil
+	default:
+		return errors.Errorf("unsupported archive format '%s'", f)
+	}
+}
+
+// extension returns the file extension of compressed archive files in the
+// format.
+func (f Format) extension() string {
+	return "." + string(f) + ".gz"
+}
+
+// Manifest describes the files in an archive directory.
+type Manifest struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection"`
+	Format     Format `json:"format"`
+	// Filter is the extended JSON query for the archived documents.
+	Filter    string    `json:"filter"`
+	CreatedAt time.Time `json:"created_at"`
+	Documents int64     `json:"documents"`
+	Files     []File    `json:"files"`
+}
+
+// File is a compressed file in an archive.
+type File struct {
+	Name      string `json:"name"`
+	Documents int64  `json:"documents"`
+	// Size is the size of the compressed file in bytes.
+	Size int64 `json:"size"`
+	// SHA256 is the hex-encoded SHA-256 checksum of the compressed file.
+	SHA256 string `json:"sha256"`
+}
+
+// WriteManifest writes the manifest to the archive directory. The manifest is
+// replaced atomically, so an archive directory only has a manifest once all
+// its files have been written.
+func WriteManifest(dir string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshalling manifest")
+	}
+
+	tmpPath := filepath.Join(dir, ManifestFile+".tmp")
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return errors.Wrap(err, "writing manifest")
+	}
+	return errors.Wrap(os.Rename(tmpPath, filepath.Join(dir, ManifestFile)), "replacing manifest")
+}
+
+// ReadManifest reads the manifest in the archive directory. It returns an
+// error satisfying os.IsNotExist if there is no manifest.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.Wrap(err, "parsing manifest")
+	}
+	return manifest, errors.Wrap(manifest.Format.Validate(), "invalid manifest")
+}
+
+// VerifyFile checks that the archive file has the size and checksum recorded
+// in the manifest.
+func VerifyFile(dir string, file File) error {
+	f, err := os.Open(filepath.Join(dir, file.Name))
+	if err != nil {
+		return errors.Wrapf(err, "opening archive file '%s'", file.Name)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return errors.Wrapf(err, "reading archive file '%s'", file.Name)
+	}
+	if size != file.Size {
+		return errors.Errorf("archive file '%s' is %d bytes, but the manifest says it's %d bytes", file.Name, size, file.Size)
+	}
+	if sum := hex.EncodeToString(hash.Sum(nil)); sum != file.SHA256 {
+		return errors.Errorf("archive file '%s' has checksum %s, but the manifest says it's %s", file.Name, sum, file.SHA256)
+	}
+	return nil
+}
+
+// ReadFile decodes the documents in the compressed archive file and calls fn
+// with each of them in order.
+func ReadFile(dir string, file File, format Format, fn func(bson.Raw) error) error {
+	f, err := os.Open(filepath.Join(dir, file.Name))
+	if err != nil {
+		return errors.Wrapf(err, "opening archive file '%s'", file.Name)
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.Wrapf(err, "decompressing archive file '%s'", file.Name)
+	}
+	defer gz.Close()
+
+	return errors.Wrapf(Decode(gz, format, fn), "decoding archive file '%s'", file.Name)
+}
+
+// Decode decodes the uncompressed documents in the reader and calls fn with
+// each of them in order. JSONL documents may be canonical or relaxed extended
+// JSON.
+func Decode(r io.Reader, format Format, fn func(bson.Raw) error) error {
+	switch format {
+	case FormatJSONL:
+		return decodeJSONL(r, fn)
+	case FormatBSON:
+		return decodeBSON(r, fn)
+	default:
+		return format.Validate()
+	}
+}
+
+func decodeJSONL(r io.Reader, fn func(bson.Raw) error) error {
+	scanner := bufio.NewScanner(r)
+	// Extended JSON is larger than the BSON it encodes, so allow lines
+	// larger than the largest document.
+	scanner.Buffer(make([]byte, 4096), 2*maxDocumentSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var doc bson.Raw
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), false, &doc); err != nil {
+			return errors.Wrapf(err, "unmarshalling line %d", line)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return errors.Wrap(scanner.Err(), "reading lines")
+}
+
+func decodeBSON(r io.Reader, fn func(bson.Raw) error) error {
+	reader := bufio.NewReader(r)
+	for i := 0; ; i++ {
+		var length [4]byte
+		if _, err := io.ReadFull(reader, length[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "reading length of document %d", i)
+		}
+
+		size := int32(binary.LittleEndian.Uint32(length[:]))
+		if size < 5 || size > maxDocumentSize {
+			return errors.Errorf("document %d has invalid length %d", i, size)
+		}
+		doc := make([]byte, size)
+		copy(doc, length[:])
+		if _, err := io.ReadFull(reader, doc[4:]); err != nil {
+			return errors.Wrapf(err, "reading document %d", i)
+		}
+		if err := bson.Raw(doc).Validate(); err != nil {
+			return errors.Wrapf(err, "validating document %d", i)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWriteAndReadArchive(t *testing.T) {
+	docs := []bson.D{
+		{{Key: "_id", Value: "a"}, {Key: "count", Value: int64(1)}},
+		{{Key: "_id", Value: "b"}, {Key: "key", Value: primitive.Binary{Data: []byte("secret")}}},
+		{{Key: "_id", Value: "c"}, {Key: "nested", Value: bson.D{{Key: "n", Value: int32(2)}}}},
+	}
+
+	for _, format := range []Format{FormatJSONL, FormatBSON} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			// Every document is larger than the maximum, so each gets a
+			// file of its own.
+			writer, err := NewWriter(dir, "tasks", format, 1)
+			require.NoError(t, err)
+			for _, doc := range docs {
+				raw, err := bson.Marshal(doc)
+				require.NoError(t, err)
+				require.NoError(t, writer.Write(raw))
+			}
+			files, err := writer.Close()
+			require.NoError(t, err)
+			require.Len(t, files, len(docs))
+
+			matches, err := filepath.Glob(FilePattern(dir, "tasks", format))
+			require.NoError(t, err)
+			assert.Len(t, matches, len(docs))
+
+			var read []bson.D
+			for _, file := range files {
+				assert.EqualValues(t, 1, file.Documents)
+				require.NoError(t, VerifyFile(dir, file))
+				require.NoError(t, ReadFile(dir, file, format, func(raw bson.Raw) error {
+					var doc bson.D
+					require.NoError(t, bson.Unmarshal(raw, &doc))
+					read = append(read, doc)
+					return nil
+				}))
+			}
+			assert.Equal(t, docs, read, "documents should round trip without changing types")
+		})
+	}
+}
+
+func TestWriterCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewWriter(dir, "tasks", FormatJSONL, 1024)
+	require.NoError(t, err)
+
+	files, err := writer.Checkpoint()
+	require.NoError(t, err)
+	assert.Empty(t, files, "checkpoint before any writes should not create a file")
+
+	for i, id := range []string{"a", "b"} {
+		raw, err := bson.Marshal(bson.M{"_id": id})
+		require.NoError(t, err)
+		require.NoError(t, writer.Write(raw))
+
+		files, err = writer.Checkpoint()
+		require.NoError(t, err)
+		require.Len(t, files, i+1, "each checkpoint should finish a file")
+		require.NoError(t, VerifyFile(dir, files[i]))
+	}
+
+	closed, err := writer.Close()
+	require.NoError(t, err)
+	assert.Equal(t, files, closed)
+}
+
+func TestVerifyFile(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewWriter(dir, "tasks", FormatJSONL, 1024)
+	require.NoError(t, err)
+	raw, err := bson.Marshal(bson.M{"_id": "a"})
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(raw))
+	files, err := writer.Close()
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	require.NoError(t, VerifyFile(dir, files[0]))
+
+	path := filepath.Join(dir, files[0].Name)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0644))
+	err = VerifyFile(dir, files[0])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum")
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	_, err := ReadManifest(dir)
+	assert.True(t, os.IsNotExist(err))
+
+	manifest := &Manifest{
+		Database:   "mci",
+		Collection: "tasks",
+		Format:     FormatBSON,
+		Documents:  1,
+		Files:      []File{{Name: "tasks-00000.bson.gz", Documents: 1, Size: 10, SHA256: "abc"}},
+	}
+	require.NoError(t, WriteManifest(dir, manifest))
+	read, err := ReadManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, manifest, read)
+}
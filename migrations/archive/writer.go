@@ -0,0 +1,169 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Writer writes documents to a sequence of compressed archive files, starting
+// a new file when the current one would exceed the maximum size.
+type Writer struct {
+	dir         string
+	prefix      string
+	format      Format
+	maxFileSize int64
+
+	files   []File
+	current *fileWriter
+}
+
+// fileWriter writes documents to a single compressed archive file, hashing the
+// compressed bytes as they're written.
+type fileWriter struct {
+	file     File
+	f        *os.File
+	hash     hash.Hash
+	counter  *countingWriter
+	buffered *bufio.Writer
+	gz       *gzip.Writer
+	// uncompressedSize is the number of bytes written before compression.
+	uncompressedSize int64
+}
+
+type countingWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// NewWriter returns a writer that writes files named
+// <prefix>-<number>.<format>.gz to the directory. maxFileSize is the most
+// uncompressed bytes to write to each file, though a single document larger
+// than that gets a file of its own.
+func NewWriter(dir, prefix string, format Format, maxFileSize int64) (*Writer, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	if maxFileSize <= 0 {
+		return nil, errors.New("maximum file size must be positive")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "creating archive directory '%s'", dir)
+	}
+
+	return &Writer{
+		dir:         dir,
+		prefix:      prefix,
+		format:      format,
+		maxFileSize: maxFileSize,
+	}, nil
+}
+
+// FilePattern returns the glob pattern that matches the files a writer with
+// the prefix and format writes.
+func FilePattern(dir, prefix string, format Format) string {
+	return filepath.Join(dir, prefix+"-*"+format.extension())
+}
+
+// Write adds the document to the archive.
+func (w *Writer) Write(doc bson.Raw) error {
+	data := []byte(doc)
+	if w.format == FormatJSONL {
+		var err error
+		data, err = bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return errors.Wrap(err, "marshalling document to extended JSON")
+		}
+		data = append(data, '\n')
+	}
+
+	if w.current != nil && w.current.uncompressedSize > 0 && w.current.uncompressedSize+int64(len(data)) > w.maxFileSize {
+		if err := w.closeCurrent(); err != nil {
+			return err
+		}
+	}
+	if w.current == nil {
+		if err := w.openNext(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := w.current.gz.Write(data); err != nil {
+		return errors.Wrapf(err, "writing to archive file '%s'", w.current.file.Name)
+	}
+	w.current.uncompressedSize += int64(len(data))
+	w.current.file.Documents++
+	return nil
+}
+
+// Checkpoint finishes the current file, so that everything written so far is
+// on disk, and returns all the files written. The next document is written to
+// a new file.
+func (w *Writer) Checkpoint() ([]File, error) {
+	if w.current != nil {
+		if err := w.closeCurrent(); err != nil {
+			return nil, err
+		}
+	}
+	return append([]File{}, w.files...), nil
+}
+
+// Close finishes the current file and returns all the files written.
+func (w *Writer) Close() ([]File, error) {
+	return w.Checkpoint()
+}
+
+func (w *Writer) openNext() error {
+	name := fmt.Sprintf("%s-%05d%s", w.prefix, len(w.files), w.format.extension())
+	f, err := os.Create(filepath.Join(w.dir, name))
+	if err != nil {
+		return errors.Wrapf(err, "creating archive file '%s'", name)
+	}
+
+	current := &fileWriter{
+		file: File{Name: name},
+		f:    f,
+		hash: sha256.New(),
+	}
+	current.counter = &countingWriter{w: io.MultiWriter(f, current.hash)}
+	current.buffered = bufio.NewWriter(current.counter)
+	current.gz = gzip.NewWriter(current.buffered)
+	w.current = current
+	return nil
+}
+
+// closeCurrent flushes the current file to disk and records it.
+func (w *Writer) closeCurrent() error {
+	current := w.current
+	w.current = nil
+
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(current.gz.Close(), "finishing compression")
+	catcher.Wrap(current.buffered.Flush(), "flushing")
+	catcher.Wrap(current.f.Sync(), "syncing to disk")
+	catcher.Wrap(current.f.Close(), "closing")
+	if catcher.HasErrors() {
+		return errors.Wrapf(catcher.Resolve(), "archive file '%s'", current.file.Name)
+	}
+
+	current.file.Size = current.counter.count
+	current.file.SHA256 = hex.EncodeToString(current.hash.Sum(nil))
+	w.files = append(w.files, current.file)
+	return nil
+}
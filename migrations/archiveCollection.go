@@ -0,0 +1,304 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	archiveCollectionName     = "archiveCollection"
+	defaultArchiveMaxFileSize = 256 * 1024 * 1024
+	defaultArchiveBatchSize   = 1000
+
+	// archiveDirEnvVar is the directory to write the archive to. It holds a
+	// single archive.
+	archiveDirEnvVar = "ARCHIVE_DIR"
+	// archiveFilterEnvVar is an extended JSON query for the documents to
+	// archive.
+	archiveFilterEnvVar = "ARCHIVE_FILTER"
+	// archiveFormatEnvVar is the format of the archive files, 'jsonl' or
+	// 'bson'. It defaults to 'jsonl'.
+	archiveFormatEnvVar = "ARCHIVE_FORMAT"
+	// archiveMaxFileSizeEnvVar is the most uncompressed bytes to write to
+	// each archive file.
+	archiveMaxFileSizeEnvVar = "ARCHIVE_MAX_FILE_SIZE"
+	// archiveDeleteEnvVar, if true, deletes the archived documents from the
+	// collection once the archive has been written and verified.
+	archiveDeleteEnvVar = "ARCHIVE_DELETE"
+
+	// maxArchiveDeleteBatchBytes limits the size of the archived documents
+	// in each delete, since each one is matched by its full contents.
+	maxArchiveDeleteBatchBytes = 8 * 1024 * 1024
+)
+
+func init() {
+	Registry.registerMigration(archiveCollectionName, newArchiveCollection, withRisk(RiskDestructive))
+}
+
+// archiveCollection writes the documents in a collection that match a filter
+// to compressed archive files, and optionally deletes them afterwards.
+type archiveCollection struct {
+	database    string
+	collection  string
+	batchSize   int
+	dir         string
+	filter      bson.M
+	filterJSON  string
+	format      archive.Format
+	maxFileSize int64
+	delete      bool
+	clock       Clock
+}
+
+func newArchiveCollection(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+	catcher.NewWhen(opts.Collection == "", "collection name not specified")
+
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultArchiveBatchSize
+	}
+
+	a := &archiveCollection{
+		database:    opts.Database,
+		collection:  opts.Collection,
+		batchSize:   opts.BatchSize,
+		dir:         os.Getenv(archiveDirEnvVar),
+		filter:      bson.M{},
+		filterJSON:  os.Getenv(archiveFilterEnvVar),
+		format:      archive.FormatJSONL,
+		maxFileSize: defaultArchiveMaxFileSize,
+		clock:       opts.getClock(),
+	}
+	catcher.ErrorfWhen(a.dir == "", "expected environment variable '%s' was not specified", archiveDirEnvVar)
+
+	if a.filterJSON != "" {
+		catcher.Wrapf(bson.UnmarshalExtJSON([]byte(a.filterJSON), false, &a.filter), "parsing '%s'", archiveFilterEnvVar)
+	}
+	if formatStr := os.Getenv(archiveFormatEnvVar); formatStr != "" {
+		a.format = archive.Format(formatStr)
+		catcher.Wrapf(a.format.Validate(), "parsing '%s'", archiveFormatEnvVar)
+	}
+	if sizeStr := os.Getenv(archiveMaxFileSizeEnvVar); sizeStr != "" {
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		catcher.Wrapf(err, "parsing '%s'", archiveMaxFileSizeEnvVar)
+		catcher.ErrorfWhen(err == nil && size <= 0, "'%s' must be positive", archiveMaxFileSizeEnvVar)
+		a.maxFileSize = size
+	}
+	if deleteStr := os.Getenv(archiveDeleteEnvVar); deleteStr != "" {
+		del, err := strconv.ParseBool(deleteStr)
+		catcher.Wrapf(err, "parsing '%s'", archiveDeleteEnvVar)
+		a.delete = del
+	}
+
+	return a, catcher.Resolve()
+}
+
+// Execute writes the matching documents to the archive and writes the
+// manifest once they've all been written. If the archive directory already
+// has a manifest for the collection, the archive is complete and isn't
+// written again. If deletion is enabled, the archived documents are then
+// deleted, but only those read back from archive files that match their
+// checksums and that haven't changed since they were archived, so if the
+// script is interrupted, it resumes deleting where it left off.
+func (a *archiveCollection) Execute(ctx context.Context, client *mongo.Client) error {
+	manifest, err := archive.ReadManifest(a.dir)
+	if os.IsNotExist(err) {
+		manifest, err = a.writeArchive(ctx, client)
+		if err != nil {
+			return errors.Wrap(err, "writing archive")
+		}
+	} else if err != nil {
+		return errors.Wrap(err, "reading existing manifest")
+	} else {
+		if manifest.Database != a.database || manifest.Collection != a.collection || manifest.Filter != a.filterJSON {
+			return errors.Errorf("archive directory '%s' already has an archive of '%s.%s' with filter '%s'", a.dir, manifest.Database, manifest.Collection, manifest.Filter)
+		}
+		grip.Infof("Archive in '%s' is already complete with %d documents", a.dir, manifest.Documents)
+	}
+
+	if !a.delete {
+		return nil
+	}
+	return errors.Wrap(a.deleteArchived(ctx, client, manifest), "deleting archived documents")
+}
+
+// Preflight estimates the number of documents that will be deleted, which is
+// none unless deletion is enabled.
+func (a *archiveCollection) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	var count int64
+	if a.delete {
+		var err error
+		count, err = client.Database(a.database).Collection(a.collection).CountDocuments(ctx, a.filter)
+		if err != nil {
+			return nil, errors.Wrap(err, "counting documents to archive")
+		}
+	}
+
+	return &PreflightRequirements{
+		Collections:        []string{a.collection},
+		EstimatedDocuments: count,
+	}, nil
+}
+
+func (a *archiveCollection) writeArchive(ctx context.Context, client *mongo.Client) (*archive.Manifest, error) {
+	// Remove the files of an earlier attempt that was interrupted before it
+	// wrote the manifest.
+	stale, err := filepath.Glob(archive.FilePattern(a.dir, a.collection, a.format))
+	if err != nil {
+		return nil, errors.Wrap(err, "finding incomplete archive files")
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrapf(err, "removing incomplete archive file '%s'", path)
+		}
+	}
+
+	writer, err := archive.NewWriter(a.dir, a.collection, a.format, a.maxFileSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating archive writer")
+	}
+
+	cur, err := client.Database(a.database).Collection(a.collection).Find(ctx, a.filter, options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetBatchSize(int32(a.batchSize)))
+	if err != nil {
+		return nil, errors.Wrap(err, "finding documents to archive")
+	}
+	defer cur.Close(ctx)
+
+	var count int64
+	for cur.Next(ctx) {
+		if err := writer.Write(cur.Current); err != nil {
+			return nil, err
+		}
+		count++
+		if count%int64(a.batchSize) == 0 {
+			grip.Infof("Archived %d documents from collection '%s'", count, a.collection)
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating over documents to archive")
+	}
+
+	files, err := writer.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "closing archive writer")
+	}
+	manifest := &archive.Manifest{
+		Database:   a.database,
+		Collection: a.collection,
+		Format:     a.format,
+		Filter:     a.filterJSON,
+		CreatedAt:  a.clock.Now(),
+		Documents:  count,
+		Files:      files,
+	}
+	if err := archive.WriteManifest(a.dir, manifest); err != nil {
+		return nil, err
+	}
+
+	grip.Infof("Archived %d documents from collection '%s' to %d files in '%s'", count, a.collection, len(files), a.dir)
+	return manifest, nil
+}
+
+// deleteArchived deletes the documents in the archive that still match the
+// filter and are unchanged since they were archived, after checking each
+// archive file against its checksum. Documents that have changed since they
+// were archived are skipped and reported, since their current contents aren't
+// in the archive.
+func (a *archiveCollection) deleteArchived(ctx context.Context, client *mongo.Client, manifest *archive.Manifest) error {
+	coll := client.Database(a.database).Collection(a.collection)
+	var deleted, changed int64
+	deleteBatch := func(docs []bson.Raw) error {
+		ids := make(bson.A, 0, len(docs))
+		unchanged := make(bson.A, 0, len(docs))
+		for _, doc := range docs {
+			id := doc.Lookup("_id")
+			ids = append(ids, id)
+			unchanged = append(unchanged, bson.M{
+				"_id":   id,
+				"$expr": bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": doc}}},
+			})
+		}
+
+		res, err := coll.DeleteMany(ctx, a.withFilter(bson.M{"$or": unchanged}))
+		if err != nil {
+			return errors.Wrap(err, "deleting batch of archived documents")
+		}
+		deleted += res.DeletedCount
+
+		// The documents in the batch that still match the filter weren't
+		// deleted because they've changed since they were archived.
+		cur, err := coll.Find(ctx, a.withFilter(bson.M{"_id": bson.M{"$in": ids}}), options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return errors.Wrap(err, "finding archived documents that have changed")
+		}
+		var remaining []struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cur.All(ctx, &remaining); err != nil {
+			return errors.Wrap(err, "iterating over archived documents that have changed")
+		}
+		for _, doc := range remaining {
+			grip.Warningf("Not deleting document '%v' because it has changed since it was archived", doc.ID)
+		}
+		changed += int64(len(remaining))
+		return nil
+	}
+
+	for _, file := range manifest.Files {
+		if err := archive.VerifyFile(a.dir, file); err != nil {
+			return err
+		}
+
+		docs := make([]bson.Raw, 0, a.batchSize)
+		var batchBytes int
+		if err := archive.ReadFile(a.dir, file, manifest.Format, func(doc bson.Raw) error {
+			if _, err := doc.LookupErr("_id"); err != nil {
+				return errors.Wrap(err, "finding ID of archived document")
+			}
+			docs = append(docs, doc)
+			batchBytes += len(doc)
+			if len(docs) < a.batchSize && batchBytes < maxArchiveDeleteBatchBytes {
+				return nil
+			}
+			err := deleteBatch(docs)
+			docs = docs[:0]
+			batchBytes = 0
+			return err
+		}); err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			if err := deleteBatch(docs); err != nil {
+				return err
+			}
+		}
+		grip.Infof("Deleted documents archived in '%s'; %d deleted so far", file.Name, deleted)
+	}
+
+	grip.Infof("Deleted %d of %d archived documents from collection '%s'", deleted, manifest.Documents, a.collection)
+	if changed > 0 {
+		grip.Warningf("Skipped %d archived documents in collection '%s' that have changed since they were archived", changed, a.collection)
+	}
+	return nil
+}
+
+// withFilter restricts the query to the documents that match the archive
+// filter.
+func (a *archiveCollection) withFilter(query bson.M) bson.M {
+	if len(a.filter) == 0 {
+		return query
+	}
+	return bson.M{"$and": bson.A{a.filter, query}}
+}
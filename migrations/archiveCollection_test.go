@@ -0,0 +1,90 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const testArchiveFilter = `{"create_time": {"$lt": {"$date": "2024-01-01T00:00:00Z"}}}`
+
+func TestArchiveCollection(t *testing.T) {
+	for _, format := range []archive.Format{archive.FormatJSONL, archive.FormatBSON} {
+		t.Run(string(format), func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv(archiveDirEnvVar, dir)
+			t.Setenv(archiveFilterEnvVar, testArchiveFilter)
+			t.Setenv(archiveFormatEnvVar, string(format))
+			t.Setenv(archiveMaxFileSizeEnvVar, "150")
+			t.Setenv(archiveDeleteEnvVar, "true")
+
+			runGoldenTest(t, archiveCollectionName, "deleteOld", MigrationOptions{Collection: "tasks", BatchSize: 2})
+
+			manifest, err := archive.ReadManifest(dir)
+			require.NoError(t, err)
+			assert.Equal(t, "tasks", manifest.Collection)
+			assert.Equal(t, testArchiveFilter, manifest.Filter)
+			assert.EqualValues(t, 3, manifest.Documents)
+			assert.Greater(t, len(manifest.Files), 1, "archive should be split into more than one file")
+
+			var ids []string
+			var total int64
+			for _, file := range manifest.Files {
+				require.NoError(t, archive.VerifyFile(dir, file))
+				total += file.Documents
+				require.NoError(t, archive.ReadFile(dir, file, format, func(doc bson.Raw) error {
+					ids = append(ids, doc.Lookup("_id").StringValue())
+					return nil
+				}))
+			}
+			assert.Equal(t, manifest.Documents, total)
+			assert.Equal(t, []string{"old_0", "old_1", "old_2"}, ids)
+		})
+	}
+}
+
+func TestArchiveCollectionSkipsChangedDocuments(t *testing.T) {
+	t.Setenv(archiveDirEnvVar, t.TempDir())
+	t.Setenv(archiveFilterEnvVar, testArchiveFilter)
+	opts := MigrationOptions{Collection: "tasks"}
+
+	// A document that changes between archiving and deleting is kept.
+	runGoldenTestAfter(t, archiveCollectionName, "changedAfterArchive", opts, func(ctx context.Context, db *mongo.Database) {
+		opts.Database = db.Name()
+		archiver, err := Registry.Migration(archiveCollectionName, opts)
+		require.NoError(t, err)
+		require.NoError(t, archiver.Execute(ctx, db.Client()))
+
+		_, err = db.Collection("tasks").UpdateByID(ctx, "old_1", bson.M{"$set": bson.M{"status": "success"}})
+		require.NoError(t, err)
+
+		t.Setenv(archiveDeleteEnvVar, "true")
+	})
+}
+
+func TestNewArchiveCollection(t *testing.T) {
+	opts := MigrationOptions{Database: "db", Collection: "tasks"}
+
+	t.Run("MissingDir", func(t *testing.T) {
+		_, err := newArchiveCollection(opts)
+		assert.Error(t, err)
+	})
+	t.Run("InvalidFormat", func(t *testing.T) {
+		t.Setenv(archiveDirEnvVar, t.TempDir())
+		t.Setenv(archiveFormatEnvVar, "csv")
+		_, err := newArchiveCollection(opts)
+		assert.Error(t, err)
+	})
+	t.Run("Defaults", func(t *testing.T) {
+		t.Setenv(archiveDirEnvVar, t.TempDir())
+		migration, err := newArchiveCollection(opts)
+		require.NoError(t, err)
+		assert.Equal(t, archive.FormatJSONL, migration.(*archiveCollection).format)
+		assert.False(t, migration.(*archiveCollection).delete)
+	})
+}
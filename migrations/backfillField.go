@@ -0,0 +1,224 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	backfillFieldName             = "backfillField"
+	defaultBackfillFieldBatchSize = 1000
+	// backfillDryRunSampleSize is the number of documents whose computed
+	// values are printed in a dry run.
+	backfillDryRunSampleSize = 10
+
+	// backfillFieldEnvVar is the dotted path of the field to set.
+	backfillFieldEnvVar = "BACKFILL_FIELD"
+	// backfillValueEnvVar is the extended JSON value to set the field to.
+	backfillValueEnvVar = "BACKFILL_VALUE"
+	// backfillExpressionEnvVar is an extended JSON aggregation expression
+	// that computes the value to set the field to from the rest of the
+	// document.
+	backfillExpressionEnvVar = "BACKFILL_EXPRESSION"
+	// backfillFilterEnvVar is an extended JSON query that limits the
+	// documents that are backfilled.
+	backfillFilterEnvVar = "BACKFILL_FILTER"
+	// backfillDryRunEnvVar, if true, prints what would be backfilled without
+	// modifying any documents.
+	backfillDryRunEnvVar = "BACKFILL_DRY_RUN"
+)
+
+func init() {
+	Registry.registerMigration(backfillFieldName, newBackfillField, withRisk(RiskWrite))
+}
+
+// backfillField sets a field in the documents of a collection that don't have
+// it, either to a constant or to a value computed from the document.
+type backfillField struct {
+	database   string
+	collection string
+	batchSize  int
+	field      string
+	// Exactly one of value and expression is set.
+	value      interface{}
+	expression interface{}
+	filter     bson.M
+	dryRun     bool
+}
+
+func newBackfillField(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+	catcher.NewWhen(opts.Collection == "", "collection name not specified")
+
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultBackfillFieldBatchSize
+	}
+
+	b := &backfillField{
+		database:   opts.Database,
+		collection: opts.Collection,
+		batchSize:  opts.BatchSize,
+		field:      os.Getenv(backfillFieldEnvVar),
+		filter:     bson.M{},
+	}
+	catcher.ErrorfWhen(b.field == "", "expected environment variable '%s' was not specified", backfillFieldEnvVar)
+
+	valueStr, hasValue := os.LookupEnv(backfillValueEnvVar)
+	expressionStr, hasExpression := os.LookupEnv(backfillExpressionEnvVar)
+	catcher.ErrorfWhen(hasValue == hasExpression, "exactly one of '%s' and '%s' must be specified", backfillValueEnvVar, backfillExpressionEnvVar)
+	if hasValue {
+		value, err := parseExtJSONValue(valueStr)
+		catcher.Wrapf(err, "parsing '%s'", backfillValueEnvVar)
+		b.value = value
+	}
+	if hasExpression {
+		expression, err := parseExtJSONValue(expressionStr)
+		catcher.Wrapf(err, "parsing '%s'", backfillExpressionEnvVar)
+		b.expression = expression
+	}
+
+	if filterStr := os.Getenv(backfillFilterEnvVar); filterStr != "" {
+		catcher.Wrapf(bson.UnmarshalExtJSON([]byte(filterStr), false, &b.filter), "parsing '%s'", backfillFilterEnvVar)
+	}
+
+	if dryRunStr := os.Getenv(backfillDryRunEnvVar); dryRunStr != "" {
+		dryRun, err := strconv.ParseBool(dryRunStr)
+		catcher.Wrapf(err, "parsing '%s'", backfillDryRunEnvVar)
+		b.dryRun = dryRun
+	}
+
+	return b, catcher.Resolve()
+}
+
+// parseExtJSONValue parses a single extended JSON value, which may be a
+// document, an array, or a scalar.
+func parseExtJSONValue(value string) (interface{}, error) {
+	var doc struct {
+		Value interface{} `bson:"value"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(fmt.Sprintf(`{"value": %s}`, value)), false, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Value, nil
+}
+
+// Execute sets the field in batches of documents, ordered by ID, that match
+// the filter and don't have the field. Backfilled documents no longer match,
+// so if the script is interrupted, it resumes where it left off.
+func (b *backfillField) Execute(ctx context.Context, client *mongo.Client) error {
+	coll := client.Database(b.database).Collection(b.collection)
+
+	alreadySet, err := coll.CountDocuments(ctx, b.query(true))
+	if err != nil {
+		return errors.Wrap(err, "counting documents that already have the field")
+	}
+	if b.dryRun {
+		return b.printDryRun(ctx, coll, alreadySet)
+	}
+
+	var updated int64
+	if err := forEachIDBatch(ctx, coll, b.query(false), b.batchSize, func(ids bson.A) error {
+		// Check the field is still missing, in case it was set since the
+		// batch was found.
+		batch := bson.M{"$and": bson.A{bson.M{"_id": bson.M{"$in": ids}}, b.query(false)}}
+		res, err := coll.UpdateMany(ctx, batch, b.update())
+		if err != nil {
+			return errors.Wrapf(err, "setting field '%s'", b.field)
+		}
+		updated += res.ModifiedCount
+		grip.Infof("Set field '%s' in %d documents in collection '%s'", b.field, updated, b.collection)
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "backfilling field in batches")
+	}
+
+	grip.Infof("Set field '%s' in %d documents; %d documents already had it", b.field, updated, alreadySet)
+	if b.expression != nil {
+		unset, err := coll.CountDocuments(ctx, b.query(false))
+		if err != nil {
+			return errors.Wrap(err, "counting documents that still don't have the field")
+		}
+		grip.InfoWhen(unset > 0, fmt.Sprintf("%d documents still don't have field '%s' because the expression evaluated to a missing value for them", unset, b.field))
+	}
+
+	return nil
+}
+
+// Preflight estimates the number of documents that don't have the field.
+func (b *backfillField) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	count, err := client.Database(b.database).Collection(b.collection).CountDocuments(ctx, b.query(false))
+	if err != nil {
+		return nil, errors.Wrap(err, "counting documents without the field")
+	}
+
+	return &PreflightRequirements{
+		Collections:        []string{b.collection},
+		EstimatedDocuments: count,
+	}, nil
+}
+
+// query returns the query for documents that match the filter and have or
+// don't have the field.
+func (b *backfillField) query(hasField bool) bson.M {
+	query := bson.M{b.field: bson.M{"$exists": hasField}}
+	if len(b.filter) == 0 {
+		return query
+	}
+	return bson.M{"$and": bson.A{b.filter, query}}
+}
+
+// update returns the update that sets the field. A computed value needs an
+// update pipeline, which would treat a constant string beginning with '$' as a
+// field path, so constants are set with an update document.
+func (b *backfillField) update() interface{} {
+	if b.expression != nil {
+		return bson.A{bson.M{"$set": bson.M{b.field: b.expression}}}
+	}
+	return bson.M{"$set": bson.M{b.field: b.value}}
+}
+
+// printDryRun prints the number of documents that would be backfilled and a
+// sample of the values they would be set to.
+func (b *backfillField) printDryRun(ctx context.Context, coll *mongo.Collection, alreadySet int64) error {
+	toUpdate, err := coll.CountDocuments(ctx, b.query(false))
+	if err != nil {
+		return errors.Wrap(err, "counting documents without the field")
+	}
+	fmt.Printf("Dry run: would set field '%s' in %d document(s); %d document(s) already have it\n", b.field, toUpdate, alreadySet)
+
+	value := b.expression
+	if value == nil {
+		// Wrap the constant so it isn't evaluated as an expression.
+		value = bson.M{"$literal": b.value}
+	}
+	cur, err := coll.Aggregate(ctx, bson.A{
+		bson.M{"$match": b.query(false)},
+		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$limit": backfillDryRunSampleSize},
+		bson.M{"$project": bson.M{"_id": 1, "value": value}},
+	})
+	if err != nil {
+		return errors.Wrap(err, "computing sample values")
+	}
+	var samples []bson.Raw
+	if err := cur.All(ctx, &samples); err != nil {
+		return errors.Wrap(err, "iterating over sample values")
+	}
+	for _, sample := range samples {
+		sampleJSON, err := bson.MarshalExtJSON(sample, false, false)
+		if err != nil {
+			return errors.Wrap(err, "marshalling sample value to JSON")
+		}
+		fmt.Println(string(sampleJSON))
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBackfillField      = "has_annotations"
+	testBackfillExpression = `{"$eq": ["$status", "failed"]}`
+)
+
+func TestNewBackfillField(t *testing.T) {
+	opts := MigrationOptions{Database: "db", Collection: "tasks"}
+
+	t.Run("Constant", func(t *testing.T) {
+		t.Setenv(backfillFieldEnvVar, testBackfillField)
+		t.Setenv(backfillValueEnvVar, `{"$date": "2024-01-01T00:00:00Z"}`)
+		migration, err := newBackfillField(opts)
+		require.NoError(t, err)
+		assert.NotNil(t, migration.(*backfillField).value)
+		assert.Nil(t, migration.(*backfillField).expression)
+	})
+	t.Run("ValueAndExpression", func(t *testing.T) {
+		t.Setenv(backfillFieldEnvVar, testBackfillField)
+		t.Setenv(backfillValueEnvVar, "false")
+		t.Setenv(backfillExpressionEnvVar, testBackfillExpression)
+		_, err := newBackfillField(opts)
+		assert.Error(t, err)
+	})
+	t.Run("NeitherValueNorExpression", func(t *testing.T) {
+		t.Setenv(backfillFieldEnvVar, testBackfillField)
+		_, err := newBackfillField(opts)
+		assert.Error(t, err)
+	})
+	t.Run("InvalidValue", func(t *testing.T) {
+		t.Setenv(backfillFieldEnvVar, testBackfillField)
+		t.Setenv(backfillValueEnvVar, "not json")
+		_, err := newBackfillField(opts)
+		assert.Error(t, err)
+	})
+}
+
+func TestBackfillField(t *testing.T) {
+	t.Setenv(backfillFieldEnvVar, testBackfillField)
+
+	t.Run("Constant", func(t *testing.T) {
+		t.Setenv(backfillValueEnvVar, "false")
+		t.Setenv(backfillFilterEnvVar, `{"r": "patch_request"}`)
+		runGoldenTest(t, backfillFieldName, "constant", MigrationOptions{Collection: "tasks", BatchSize: 2})
+	})
+	t.Run("Expression", func(t *testing.T) {
+		t.Setenv(backfillExpressionEnvVar, testBackfillExpression)
+		runGoldenTest(t, backfillFieldName, "expression", MigrationOptions{Collection: "tasks", BatchSize: 2})
+	})
+	t.Run("DryRun", func(t *testing.T) {
+		t.Setenv(backfillExpressionEnvVar, testBackfillExpression)
+		t.Setenv(backfillDryRunEnvVar, "true")
+
+		// A dry run leaves the fixture as it is.
+		runGoldenTest(t, backfillFieldName, "dryRun", MigrationOptions{Collection: "tasks"})
+	})
+}
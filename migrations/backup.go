@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	preImageBackupMaxFileSize   = 256 * 1024 * 1024
+	preImageBackupTimestampForm = "20060102T150405Z"
+)
+
+// preImageBackup is an archive of documents as they were before a script
+// changed or deleted them. restoreCollection can restore it in upsert mode to
+// reverse the changes.
+type preImageBackup struct {
+	dir      string
+	writer   *archive.Writer
+	manifest *archive.Manifest
+}
+
+// newPreImageBackup creates a new directory for a backup of the collection
+// within the backup directory, so runs never overwrite each other's backups.
+func newPreImageBackup(backupDir, database, collection string, clock Clock) (*preImageBackup, error) {
+	if err := os.MkdirAll(backupDir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "creating backup directory '%s'", backupDir)
+	}
+	now := clock.Now().UTC()
+	dir, err := os.MkdirTemp(backupDir, fmt.Sprintf("%s-%s-", collection, now.Format(preImageBackupTimestampForm)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating backup in '%s'", backupDir)
+	}
+	writer, err := archive.NewWriter(dir, collection, archive.FormatJSONL, preImageBackupMaxFileSize)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating backup writer")
+	}
+
+	return &preImageBackup{
+		dir:    dir,
+		writer: writer,
+		manifest: &archive.Manifest{
+			Database:   database,
+			Collection: collection,
+			Format:     archive.FormatJSONL,
+			CreatedAt:  now,
+		},
+	}, nil
+}
+
+// write backs up the documents with the IDs and returns the IDs of the
+// documents that were backed up. The documents are on disk and in the
+// manifest when it returns, so the caller may then change them.
+func (b *preImageBackup) write(ctx context.Context, coll *mongo.Collection, ids bson.A) (bson.A, error) {
+	cur, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "finding documents to back up")
+	}
+	defer cur.Close(ctx)
+
+	backedUp := bson.A{}
+	for cur.Next(ctx) {
+		if err := b.writer.Write(cur.Current); err != nil {
+			return nil, err
+		}
+		backedUp = append(backedUp, cur.Current.Lookup("_id"))
+	}
+	if err := cur.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating over documents to back up")
+	}
+
+	files, err := b.writer.Checkpoint()
+	if err != nil {
+		return nil, errors.Wrap(err, "writing backup files")
+	}
+	b.manifest.Documents += int64(len(backedUp))
+	b.manifest.Files = files
+	if err := archive.WriteManifest(b.dir, b.manifest); err != nil {
+		return nil, err
+	}
+	return backedUp, nil
+}
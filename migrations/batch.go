@@ -0,0 +1,49 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// forEachIDBatch calls fn with the IDs of the documents matching the query,
+// in batches ordered by ID. Each batch is found after fn has processed the one
+// before it, starting after the last ID of the previous batch, so fn may
+// modify documents so that they no longer match the query.
+func forEachIDBatch(ctx context.Context, coll *mongo.Collection, query bson.M, batchSize int, fn func(ids bson.A) error) error {
+	var lastID interface{}
+	for {
+		batchQuery := query
+		if lastID != nil {
+			batchQuery = bson.M{"$and": bson.A{query, bson.M{"_id": bson.M{"$gt": lastID}}}}
+		}
+		cur, err := coll.Find(ctx, batchQuery, options.Find().
+			SetProjection(bson.M{"_id": 1}).
+			SetSort(bson.M{"_id": 1}).
+			SetLimit(int64(batchSize)))
+		if err != nil {
+			return errors.Wrap(err, "finding batch of documents")
+		}
+		var docs []struct {
+			ID interface{} `bson:"_id"`
+		}
+		if err := cur.All(ctx, &docs); err != nil {
+			return errors.Wrap(err, "iterating over batch of documents")
+		}
+		if len(docs) == 0 {
+			return nil
+		}
+
+		ids := make(bson.A, 0, len(docs))
+		for _, doc := range docs {
+			ids = append(ids, doc.ID)
+		}
+		if err := fn(ids); err != nil {
+			return err
+		}
+		lastID = ids[len(ids)-1]
+	}
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"context"
+	"time"
+)
+
+// Clock is the source of time for migrations. Migrations should get the
+// current time and wait through their clock rather than calling the time
+// package directly so that tests can control time.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Sleep waits for the duration to elapse. It returns early with the
+	// context's error if the context is done first.
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// realClock is a Clock backed by the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -3,7 +3,9 @@ package migrations
 import (
 	"context"
 	"fmt"
-	"time"
+	"io"
+	"os"
+	"sort"
 
 	"github.com/evergreen-ci/evergreen"
 	"github.com/evergreen-ci/evergreen/model/annotations"
@@ -21,12 +23,14 @@ const (
 )
 
 func init() {
-	Registry.registerMigration(missingAnnotationCountName, NewCountMissingAnnotations)
+	Registry.registerMigration(missingAnnotationCountName, NewCountMissingAnnotations, withRisk(RiskReadOnly))
 }
 
 type CountMissingAnnotations struct {
 	database   string
 	collection string
+	clock      Clock
+	out        io.Writer
 }
 
 func NewCountMissingAnnotations(opts MigrationOptions) (Migration, error) {
@@ -35,11 +39,27 @@ func NewCountMissingAnnotations(opts MigrationOptions) (Migration, error) {
 
 	return &CountMissingAnnotations{
 		database: opts.Database,
+		clock:    opts.getClock(),
+		out:      os.Stdout,
 	}, catcher.Resolve()
 }
 
 func (c *CountMissingAnnotations) Execute(ctx context.Context, client *mongo.Client) error {
-	timeToCheck := time.Now().AddDate(0, 0, -30)
+	taskIdsWithoutAnnotations, err := c.findTasksWithoutAnnotations(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(taskIdsWithoutAnnotations)
+	_, err = fmt.Fprintf(c.out, "%d task(s) without annotations: %v\n", len(taskIdsWithoutAnnotations), taskIdsWithoutAnnotations)
+	return errors.Wrap(err, "writing report")
+}
+
+// findTasksWithoutAnnotations returns the IDs of recent failed tasks that are
+// not marked as having annotations and have no annotation for their
+// execution.
+func (c *CountMissingAnnotations) findTasksWithoutAnnotations(ctx context.Context, client *mongo.Client) ([]string, error) {
+	timeToCheck := c.clock.Now().AddDate(0, 0, -30)
 	query := bson.M{
 		task.ProjectKey:   "mongodb-mongo-v8.0",
 		task.RequesterKey: evergreen.RepotrackerVersionRequester,
@@ -51,7 +71,7 @@ func (c *CountMissingAnnotations) Execute(ctx context.Context, client *mongo.Cli
 
 	cursor, err := client.Database(c.database).Collection(task.Collection).Find(ctx, query)
 	if err != nil {
-		return errors.Wrap(err, "finding tasks")
+		return nil, errors.Wrap(err, "finding tasks")
 	}
 
 	taskIdsWithoutAnnotations := []string{}
@@ -59,7 +79,7 @@ func (c *CountMissingAnnotations) Execute(ctx context.Context, client *mongo.Cli
 		currentTask := &task.Task{}
 		err := cursor.Decode(currentTask)
 		if err != nil {
-			return errors.Wrap(err, "decoding task")
+			return nil, errors.Wrap(err, "decoding task")
 		}
 		query := bson.M{
 			annotations.TaskIdKey:        currentTask.Id,
@@ -72,6 +92,5 @@ func (c *CountMissingAnnotations) Execute(ctx context.Context, client *mongo.Cli
 		}
 	}
 
-	fmt.Printf("%d task(s) without annotations: %v\n", len(taskIdsWithoutAnnotations), taskIdsWithoutAnnotations)
-	return nil
+	return taskIdsWithoutAnnotations, nil
 }
@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountMissingAnnotations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	fixture, err := testdata.LoadFixture(ctx, path.Join("testdata", "countMissingAnnotations"), db, client)
+	defer func() {
+		require.NoError(t, fixture.Cleanup(ctx))
+	}()
+	require.NoError(t, err)
+
+	for testName, testCase := range map[string]struct {
+		now            time.Time
+		expectedReport string
+	}{
+		"OnlyTasksInWindow": {
+			now:            time.Date(2024, 06, 30, 0, 0, 0, 0, time.UTC),
+			expectedReport: "2 task(s) without annotations: [annotated_previous_execution no_annotation]\n",
+		},
+		"WindowIncludesOlderTasks": {
+			now:            time.Date(2024, 05, 30, 0, 0, 0, 0, time.UTC),
+			expectedReport: "3 task(s) without annotations: [annotated_previous_execution no_annotation older]\n",
+		},
+		"NoTasksInWindow": {
+			now:            time.Date(2025, 01, 01, 0, 0, 0, 0, time.UTC),
+			expectedReport: "0 task(s) without annotations: []\n",
+		},
+	} {
+		t.Run(testName, func(t *testing.T) {
+			job, err := Registry.Migration(missingAnnotationCountName, MigrationOptions{
+				Database: db,
+				Clock:    testdata.NewFakeClock(testCase.now),
+			})
+			require.NoError(t, err)
+			var out bytes.Buffer
+			job.(*CountMissingAnnotations).out = &out
+
+			require.NoError(t, job.Execute(ctx, client))
+			assert.Equal(t, testCase.expectedReport, out.String())
+		})
+	}
+}
@@ -1,10 +1,15 @@
 package migrations
 
 import (
+	"bytes"
 	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"os"
 	"strconv"
 
+	"github.com/evergreen-ci/evergreen-migrations/migrations/secretstore"
 	"github.com/evergreen-ci/evergreen/model/githubapp"
 	"github.com/mongodb/grip"
 	"github.com/pkg/errors"
@@ -18,31 +23,73 @@ const (
 
 	startAtGitHubAppAuthIDEnvVar = "START_AT_GITHUB_APP_AUTH_ID"
 	githubAppAuthLimitEnvVar     = "GITHUB_APP_AUTH_LIMIT"
+	// githubAppKeySecretStoreEnvVar, if set, is the URL of the secret store
+	// to move each private key to before deleting it. No production secret
+	// store is supported yet.
+	githubAppKeySecretStoreEnvVar = "GITHUB_APP_KEY_SECRET_STORE"
+
+	// githubAppPrivateKeySecretName is the name of the private key in the
+	// secret store, where it's kept with the ID of its GitHub app auth.
+	githubAppPrivateKeySecretName = "github_app_private_key"
+	// githubAppPrivateKeyRefKey is the field that records where a private key
+	// was moved to in the secret store.
+	githubAppPrivateKeyRefKey = "private_key_ref"
 )
 
 func init() {
-	Registry.registerMigration(deleteGitHubAppKeysName, newDeleteGitHubAppKeys)
+	Registry.registerMigration(deleteGitHubAppKeysName, newDeleteGitHubAppKeys, withRisk(RiskDestructive), withIDSelection())
 }
 
 type deleteGitHubAppKeys struct {
 	database string
+	ids      *idSelection
+	// store is the secret store to move the keys to, if any.
+	store secretstore.Store
 }
 
 func newDeleteGitHubAppKeys(opts MigrationOptions) (Migration, error) {
 	catcher := grip.NewBasicCatcher()
 	catcher.Wrap(opts.validate(), "invalid options")
-	return &deleteGitHubAppKeys{
+	ids, err := newIDSelection()
+	catcher.Wrap(err, "invalid GitHub app auth ID selection")
+	d := &deleteGitHubAppKeys{
 		database: opts.Database,
-	}, catcher.Resolve()
+		ids:      ids,
+	}
+	if storeURL := os.Getenv(githubAppKeySecretStoreEnvVar); storeURL != "" {
+		store, err := secretstore.Open(storeURL)
+		catcher.Wrap(err, "opening secret store")
+		d.store = store
+	}
+	return d, catcher.Resolve()
 }
 
-// Execute runs a job to delete GitHub app private keys from the DB.
+// Execute runs a job to delete GitHub app private keys from the DB. If there's
+// a secret store, each key is moved to it first, and a key that can't be moved
+// is left in place without stopping the others from being moved. If the
+// context is cancelled, no more keys are moved.
 func (d *deleteGitHubAppKeys) Execute(ctx context.Context, client *mongo.Client) error {
 	ids, err := d.findGitHubAppDocIDs(ctx, client)
 	if err != nil {
 		return errors.Wrap(err, "finding GitHub app auth IDs to update")
 	}
 
+	if d.store != nil {
+		catcher := grip.NewBasicCatcher()
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				catcher.Wrap(err, "moving private keys interrupted")
+				break
+			}
+			if err := d.moveKey(ctx, client.Database(d.database).Collection(githubapp.GitHubAppAuthCollection), id); err != nil {
+				err = errors.Wrapf(err, "moving private key for GitHub app auth with ID '%s'", id)
+				grip.Warning(err)
+				catcher.Add(err)
+			}
+		}
+		return catcher.Resolve()
+	}
+
 	for _, id := range ids {
 		grip.Infof("Deleting private key for GitHub app auth with ID '%s'", id)
 		if _, err := client.Database(d.database).Collection(githubapp.GitHubAppAuthCollection).UpdateByID(ctx, id, bson.M{
@@ -57,6 +104,20 @@ func (d *deleteGitHubAppKeys) Execute(ctx context.Context, client *mongo.Client)
 	return nil
 }
 
+// Preflight estimates the number of GitHub app auth documents whose private
+// keys will be deleted.
+func (d *deleteGitHubAppKeys) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	ids, err := d.findGitHubAppDocIDs(ctx, client)
+	if err != nil {
+		return nil, errors.Wrap(err, "finding GitHub app auth IDs to update")
+	}
+
+	return &PreflightRequirements{
+		Collections:        []string{githubapp.GitHubAppAuthCollection},
+		EstimatedDocuments: int64(len(ids)),
+	}, nil
+}
+
 func (d *deleteGitHubAppKeys) findGitHubAppDocIDs(ctx context.Context, client *mongo.Client) ([]string, error) {
 	query := bson.M{
 		githubapp.GhAuthPrivateKeyKey: bson.M{"$exists": true},
@@ -67,6 +128,7 @@ func (d *deleteGitHubAppKeys) findGitHubAppDocIDs(ctx context.Context, client *m
 		query[githubapp.GhAuthIdKey] = bson.M{"$gte": startAtID}
 		opts.SetSort(bson.M{githubapp.GhAuthIdKey: 1})
 	}
+	d.ids.apply(query, githubapp.GhAuthIdKey)
 
 	var docs []githubapp.GithubAppAuth
 	if limitStr := os.Getenv(githubAppAuthLimitEnvVar); limitStr != "" {
@@ -95,3 +157,74 @@ func (d *deleteGitHubAppKeys) findGitHubAppDocIDs(ctx context.Context, client *m
 
 	return ids, nil
 }
+
+// moveKey checks that the GitHub app auth's private key is an RSA private key,
+// puts it in the secret store and reads it back to check that it was stored.
+// The same update then records the key's reference in the store and unsets the
+// key, as long as the key hasn't changed since it was read.
+func (d *deleteGitHubAppKeys) moveKey(ctx context.Context, coll *mongo.Collection, id string) error {
+	var auth githubapp.GithubAppAuth
+	if err := coll.FindOne(ctx, bson.M{githubapp.GhAuthIdKey: id}).Decode(&auth); err != nil {
+		return errors.Wrap(err, "finding GitHub app auth")
+	}
+	if len(auth.PrivateKey) == 0 {
+		return errors.New("private key is empty")
+	}
+	if err := validateRSAPrivateKeyPEM(auth.PrivateKey); err != nil {
+		return errors.Wrap(err, "validating private key")
+	}
+
+	key := secretstore.Key{ProjectID: id, Name: githubAppPrivateKeySecretName}
+	if err := d.store.Put(ctx, key, secretstore.Secret{Value: string(auth.PrivateKey), Private: true}); err != nil {
+		return errors.Wrap(err, "putting private key in the secret store")
+	}
+	stored, err := d.store.Get(ctx, key)
+	if err != nil {
+		return errors.Wrap(err, "reading back private key from the secret store")
+	}
+	if !bytes.Equal([]byte(stored.Value), auth.PrivateKey) {
+		return errors.New("private key read back from the secret store doesn't match the key put in it")
+	}
+
+	res, err := coll.UpdateOne(ctx,
+		bson.M{githubapp.GhAuthIdKey: id, githubapp.GhAuthPrivateKeyKey: auth.PrivateKey},
+		bson.M{
+			"$set":   bson.M{githubAppPrivateKeyRefKey: key.String()},
+			"$unset": bson.M{githubapp.GhAuthPrivateKeyKey: ""},
+		},
+	)
+	if err != nil {
+		return errors.Wrap(err, "unsetting private key")
+	}
+	if res.MatchedCount == 0 {
+		return errors.New("private key changed while it was being moved; rerun the script to move the new key")
+	}
+
+	grip.Infof("Moved private key for GitHub app auth with ID '%s' to the secret store as '%s'", id, key)
+	return nil
+}
+
+// validateRSAPrivateKeyPEM checks that the data is a PEM-encoded RSA private
+// key in either PKCS #1 or PKCS #8 form.
+func validateRSAPrivateKeyPEM(data []byte) error {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return errors.New("key is not PEM-encoded")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		_, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		return errors.Wrap(err, "parsing PKCS #1 private key")
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return errors.Wrap(err, "parsing PKCS #8 private key")
+		}
+		if _, ok := key.(*rsa.PrivateKey); !ok {
+			return errors.Errorf("PKCS #8 private key is a %T rather than an RSA key", key)
+		}
+		return nil
+	default:
+		return errors.Errorf("PEM block is a '%s' rather than an RSA private key", block.Type)
+	}
+}
@@ -0,0 +1,326 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	deleteOrphansName      = "deleteOrphans"
+	defaultOrphanBatchSize = 1000
+	defaultOrphanChildKey  = "_id"
+
+	// orphanChildKeyEnvVar is the field of the documents in the collection
+	// that refers to their parent. It defaults to '_id'.
+	orphanChildKeyEnvVar = "ORPHAN_CHILD_KEY"
+	// orphanParentsEnvVar is a comma-separated list of the parent
+	// collections and keys that the child key may refer to, each written as
+	// <collection>.<key>, e.g. 'project_ref._id,repo_ref._id'. A document is
+	// an orphan if none of them has a document whose key matches its child
+	// key.
+	orphanParentsEnvVar = "ORPHAN_PARENTS"
+	// orphanDeleteEnvVar, if true, deletes the orphans after backing them up.
+	// Otherwise, they're only reported.
+	orphanDeleteEnvVar = "ORPHAN_DELETE"
+	// orphanBackupDirEnvVar is the directory to back up orphans to before
+	// deleting them. Each run writes its backup to a new subdirectory, as an
+	// archive that restoreCollection can restore. It's required when deleting
+	// orphans.
+	orphanBackupDirEnvVar = "ORPHAN_BACKUP_DIR"
+)
+
+func init() {
+	Registry.registerMigration(deleteOrphansName, newDeleteOrphans, withRisk(RiskDestructive))
+}
+
+// orphanParent is a collection and key that a child document may refer to.
+type orphanParent struct {
+	collection string
+	key        string
+}
+
+func (p orphanParent) String() string {
+	return p.collection + "." + p.key
+}
+
+// parseOrphanParents parses a comma-separated list of <collection>.<key>
+// parents. The collection is everything before the first dot, so the key may
+// be a dotted path.
+func parseOrphanParents(parentsStr string) ([]orphanParent, error) {
+	catcher := grip.NewBasicCatcher()
+	var parents []orphanParent
+	for _, parentStr := range strings.Split(parentsStr, ",") {
+		parentStr = strings.TrimSpace(parentStr)
+		if parentStr == "" {
+			continue
+		}
+		collection, key, ok := strings.Cut(parentStr, ".")
+		if !ok || collection == "" || key == "" {
+			catcher.Errorf("parent '%s' must be written as <collection>.<key>", parentStr)
+			continue
+		}
+		parents = append(parents, orphanParent{collection: collection, key: key})
+	}
+	catcher.NewWhen(len(parents) == 0 && !catcher.HasErrors(), "no parents specified")
+	return parents, catcher.Resolve()
+}
+
+// deleteOrphans finds the documents in a collection whose child key doesn't
+// match a document in any of its parent collections, and optionally deletes
+// them.
+type deleteOrphans struct {
+	database   string
+	collection string
+	batchSize  int
+	childKey   string
+	parents    []orphanParent
+	delete     bool
+	backupDir  string
+	clock      Clock
+}
+
+func newDeleteOrphans(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+	catcher.NewWhen(opts.Collection == "", "collection name not specified")
+
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultOrphanBatchSize
+	}
+
+	d := &deleteOrphans{
+		database:   opts.Database,
+		collection: opts.Collection,
+		batchSize:  opts.BatchSize,
+		childKey:   defaultOrphanChildKey,
+		backupDir:  os.Getenv(orphanBackupDirEnvVar),
+		clock:      opts.getClock(),
+	}
+	if childKey := os.Getenv(orphanChildKeyEnvVar); childKey != "" {
+		d.childKey = childKey
+	}
+
+	parentsStr := os.Getenv(orphanParentsEnvVar)
+	if parentsStr == "" {
+		catcher.Errorf("expected environment variable '%s' was not specified", orphanParentsEnvVar)
+	} else {
+		parents, err := parseOrphanParents(parentsStr)
+		catcher.Wrapf(err, "parsing '%s'", orphanParentsEnvVar)
+		d.parents = parents
+	}
+	for _, parent := range d.parents {
+		catcher.ErrorfWhen(parent.collection == d.collection && parent.key == d.childKey, "parent '%s' is the child key itself", parent)
+	}
+
+	if deleteStr := os.Getenv(orphanDeleteEnvVar); deleteStr != "" {
+		del, err := strconv.ParseBool(deleteStr)
+		catcher.Wrapf(err, "parsing '%s'", orphanDeleteEnvVar)
+		d.delete = del
+	}
+	catcher.ErrorfWhen(d.delete && d.backupDir == "", "'%s' must be specified to delete orphans", orphanBackupDirEnvVar)
+
+	return d, catcher.Resolve()
+}
+
+// Execute checks the documents that have the child key in batches and reports
+// each orphan. If deletion is enabled, each batch of orphans is written to the
+// backup and synced to disk, and the manifest is updated, before they're
+// deleted, so the backup has every orphan that was deleted even if the script
+// is interrupted. Rerunning the script writes a new backup of the orphans
+// that remain.
+func (d *deleteOrphans) Execute(ctx context.Context, client *mongo.Client) error {
+	db := client.Database(d.database)
+	coll := db.Collection(d.collection)
+
+	var backup *preImageBackup
+	var checked, orphaned, deleted int64
+	err := forEachIDBatch(ctx, coll, d.childQuery(), d.batchSize, func(ids bson.A) error {
+		checked += int64(len(ids))
+		orphans, err := d.findOrphans(ctx, db, ids)
+		if err != nil {
+			return err
+		}
+		orphaned += int64(len(orphans))
+		for _, orphan := range orphans {
+			grip.Infof("Document %s in collection '%s' is an orphan: no parent matches %s %s", orphan.id, d.collection, d.childKey, orphan.ref)
+		}
+		if !d.delete || len(orphans) == 0 {
+			return nil
+		}
+
+		if backup == nil {
+			backup, err = newPreImageBackup(d.backupDir, d.database, d.collection, d.clock)
+			if err != nil {
+				return errors.Wrap(err, "creating orphan backup")
+			}
+		}
+		orphanIDs := make(bson.A, 0, len(orphans))
+		for _, orphan := range orphans {
+			orphanIDs = append(orphanIDs, orphan.id)
+		}
+		backedUp, err := backup.write(ctx, coll, orphanIDs)
+		if err != nil {
+			return errors.Wrap(err, "backing up orphans")
+		}
+
+		res, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": backedUp}})
+		if err != nil {
+			return errors.Wrap(err, "deleting batch of orphans")
+		}
+		deleted += res.DeletedCount
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "finding orphans in collection '%s'", d.collection)
+	}
+
+	if !d.delete {
+		grip.Infof("Found %d orphans among %d documents in collection '%s'", orphaned, checked, d.collection)
+		return nil
+	}
+	if backup != nil {
+		grip.Infof("Backed up orphans from collection '%s' to '%s'", d.collection, backup.dir)
+	}
+	grip.Infof("Deleted %d of %d orphans among %d documents in collection '%s'", deleted, orphaned, checked, d.collection)
+	return nil
+}
+
+// Preflight counts the orphans that will be deleted, which is none unless
+// deletion is enabled.
+func (d *deleteOrphans) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	reqs := &PreflightRequirements{Collections: []string{d.collection}}
+	for _, parent := range d.parents {
+		reqs.Collections = append(reqs.Collections, parent.collection)
+		reqs.Indexes = append(reqs.Indexes, RequiredIndex{Collection: parent.collection, Fields: []string{parent.key}})
+	}
+	if !d.delete {
+		return reqs, nil
+	}
+
+	db := client.Database(d.database)
+	err := forEachIDBatch(ctx, db.Collection(d.collection), d.childQuery(), d.batchSize, func(ids bson.A) error {
+		orphans, err := d.findOrphans(ctx, db, ids)
+		reqs.EstimatedDocuments += int64(len(orphans))
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "counting orphans in collection '%s'", d.collection)
+	}
+	return reqs, nil
+}
+
+// childQuery matches the documents that refer to a parent. Documents that
+// don't have the child key aren't orphans, since they don't refer to
+// anything.
+func (d *deleteOrphans) childQuery() bson.M {
+	return bson.M{d.childKey: bson.M{"$exists": true, "$ne": nil}}
+}
+
+// orphan is a document whose child key doesn't match any parent.
+type orphan struct {
+	id  bson.RawValue
+	ref bson.RawValue
+}
+
+// findOrphans returns the documents with the IDs whose child key doesn't
+// match a document in any of the parent collections.
+func (d *deleteOrphans) findOrphans(ctx context.Context, db *mongo.Database, ids bson.A) ([]orphan, error) {
+	cur, err := db.Collection(d.collection).Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, options.Find().
+		SetProjection(bson.M{"_id": 1, d.childKey: 1}).
+		SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, errors.Wrap(err, "finding child documents")
+	}
+	var children []bson.Raw
+	if err := cur.All(ctx, &children); err != nil {
+		return nil, errors.Wrap(err, "iterating over child documents")
+	}
+
+	var candidates []orphan
+	// The refs that no parent has matched yet, keyed by their extended JSON.
+	unmatched := map[string]bson.RawValue{}
+	for _, child := range children {
+		ref, err := child.LookupErr(strings.Split(d.childKey, ".")...)
+		if err != nil {
+			// The document no longer has the child key.
+			continue
+		}
+		candidates = append(candidates, orphan{id: child.Lookup("_id"), ref: ref})
+		unmatched[ref.String()] = ref
+	}
+
+	for _, parent := range d.parents {
+		if len(unmatched) == 0 {
+			break
+		}
+		refs := make([]bson.RawValue, 0, len(unmatched))
+		for _, ref := range unmatched {
+			refs = append(refs, ref)
+		}
+		matched, err := findParentRefs(ctx, db, parent, refs)
+		if err != nil {
+			return nil, errors.Wrapf(err, "finding parents in '%s'", parent)
+		}
+		for ref := range matched {
+			delete(unmatched, ref)
+		}
+	}
+
+	var orphans []orphan
+	for _, candidate := range candidates {
+		if _, ok := unmatched[candidate.ref.String()]; ok {
+			orphans = append(orphans, candidate)
+		}
+	}
+	return orphans, nil
+}
+
+// findParentRefs returns the refs, keyed by their extended JSON, that match
+// the key of a document in the parent collection. A ref whose value the parent
+// has exactly is matched from one query for the whole batch. The server's
+// equality also matches a number of a different type with the same value and
+// an element of an array-valued key, so every other ref is checked with its
+// own query.
+func findParentRefs(ctx context.Context, db *mongo.Database, parent orphanParent, refs []bson.RawValue) (map[string]bool, error) {
+	coll := db.Collection(parent.collection)
+	cur, err := coll.Find(ctx, bson.M{parent.key: bson.M{"$in": refs}}, options.Find().
+		SetProjection(bson.M{parent.key: 1}))
+	if err != nil {
+		return nil, err
+	}
+	var parents []bson.Raw
+	if err := cur.All(ctx, &parents); err != nil {
+		return nil, err
+	}
+
+	matched := map[string]bool{}
+	if len(parents) == 0 {
+		return matched, nil
+	}
+	for _, doc := range parents {
+		if ref, err := doc.LookupErr(strings.Split(parent.key, ".")...); err == nil {
+			matched[ref.String()] = true
+		}
+	}
+	for _, ref := range refs {
+		if matched[ref.String()] {
+			continue
+		}
+		count, err := coll.CountDocuments(ctx, bson.M{parent.key: ref}, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			matched[ref.String()] = true
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,104 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const testOrphanParents = "project_ref._id, repo_ref._id"
+
+func TestDeleteOrphans(t *testing.T) {
+	backupDir := t.TempDir()
+	t.Setenv(orphanParentsEnvVar, testOrphanParents)
+	t.Setenv(orphanDeleteEnvVar, "true")
+	t.Setenv(orphanBackupDirEnvVar, backupDir)
+
+	runGoldenTest(t, deleteOrphansName, "projectVars", MigrationOptions{Collection: "project_vars", BatchSize: 2})
+
+	entries, err := os.ReadDir(backupDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "each run should write one backup")
+	dir := filepath.Join(backupDir, entries[0].Name())
+
+	manifest, err := archive.ReadManifest(dir)
+	require.NoError(t, err)
+	assert.Equal(t, "project_vars", manifest.Collection)
+	assert.EqualValues(t, 2, manifest.Documents)
+
+	var ids []string
+	for _, file := range manifest.Files {
+		require.NoError(t, archive.VerifyFile(dir, file))
+		require.NoError(t, archive.ReadFile(dir, file, manifest.Format, func(doc bson.Raw) error {
+			ids = append(ids, doc.Lookup("_id").StringValue())
+			return nil
+		}))
+	}
+	assert.ElementsMatch(t, []string{"deleted1", "deleted2"}, ids)
+}
+
+func TestDeleteOrphansMatchesEqualRefs(t *testing.T) {
+	backupDir := t.TempDir()
+	t.Setenv(orphanChildKeyEnvVar, "build_id")
+	t.Setenv(orphanParentsEnvVar, "builds._id, versions.build_ids")
+	t.Setenv(orphanDeleteEnvVar, "true")
+	t.Setenv(orphanBackupDirEnvVar, backupDir)
+
+	// A ref matches a parent key the server considers equal to it, including
+	// a number of another type and an element of an array, but not a string.
+	runGoldenTest(t, deleteOrphansName, "equalRefs", MigrationOptions{Collection: "tasks", BatchSize: 4})
+
+	entries, err := os.ReadDir(backupDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	dir := filepath.Join(backupDir, entries[0].Name())
+	manifest, err := archive.ReadManifest(dir)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, manifest.Documents)
+}
+
+func TestParseOrphanParents(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		parents, err := parseOrphanParents("project_ref._id, tasks.details.id,")
+		require.NoError(t, err)
+		assert.Equal(t, []orphanParent{
+			{collection: "project_ref", key: "_id"},
+			{collection: "tasks", key: "details.id"},
+		}, parents)
+	})
+	t.Run("MissingKey", func(t *testing.T) {
+		_, err := parseOrphanParents("project_ref")
+		assert.Error(t, err)
+	})
+	t.Run("Empty", func(t *testing.T) {
+		_, err := parseOrphanParents(" , ")
+		assert.Error(t, err)
+	})
+}
+
+func TestNewDeleteOrphans(t *testing.T) {
+	opts := MigrationOptions{Database: "db", Collection: "project_vars"}
+
+	t.Run("MissingParents", func(t *testing.T) {
+		_, err := newDeleteOrphans(opts)
+		assert.Error(t, err)
+	})
+	t.Run("DeleteWithoutBackup", func(t *testing.T) {
+		t.Setenv(orphanParentsEnvVar, testOrphanParents)
+		t.Setenv(orphanDeleteEnvVar, "true")
+		_, err := newDeleteOrphans(opts)
+		assert.Error(t, err)
+	})
+	t.Run("Defaults", func(t *testing.T) {
+		t.Setenv(orphanParentsEnvVar, testOrphanParents)
+		migration, err := newDeleteOrphans(opts)
+		require.NoError(t, err)
+		assert.Equal(t, "_id", migration.(*deleteOrphans).childKey)
+		assert.False(t, migration.(*deleteOrphans).delete)
+	})
+}
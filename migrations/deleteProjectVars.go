@@ -21,18 +21,28 @@ const (
 )
 
 func init() {
-	Registry.registerMigration(deleteProjectVarsName, newDeleteProjectVars)
+	// Unsetting the vars loses their values unless they've been moved to the
+	// secret store first.
+	Registry.registerMigration(deleteProjectVarsName, newDeleteProjectVars,
+		withRisk(RiskDestructive),
+		withPrerequisites(migrateProjectVarsName),
+		withIDSelection(),
+	)
 }
 
 type deleteProjectVars struct {
 	database string
+	ids      *idSelection
 }
 
 func newDeleteProjectVars(opts MigrationOptions) (Migration, error) {
 	catcher := grip.NewBasicCatcher()
 	catcher.Wrap(opts.validate(), "invalid options")
+	ids, err := newIDSelection()
+	catcher.Wrap(err, "invalid project ID selection")
 	return &deleteProjectVars{
 		database: opts.Database,
+		ids:      ids,
 	}, catcher.Resolve()
 }
 
@@ -57,6 +67,20 @@ func (d *deleteProjectVars) Execute(ctx context.Context, client *mongo.Client) e
 	return nil
 }
 
+// Preflight estimates the number of project var documents whose vars will be
+// deleted.
+func (d *deleteProjectVars) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	ids, err := d.findProjectVarsDocIDs(ctx, client)
+	if err != nil {
+		return nil, errors.Wrap(err, "finding project var doc IDs to update")
+	}
+
+	return &PreflightRequirements{
+		Collections:        []string{model.ProjectVarsCollection},
+		EstimatedDocuments: int64(len(ids)),
+	}, nil
+}
+
 func (d *deleteProjectVars) findProjectVarsDocIDs(ctx context.Context, client *mongo.Client) ([]string, error) {
 	query := bson.M{
 		"vars": bson.M{"$exists": true},
@@ -67,6 +91,7 @@ func (d *deleteProjectVars) findProjectVarsDocIDs(ctx context.Context, client *m
 		query["_id"] = bson.M{"$gte": startAtID}
 		opts.SetSort(bson.M{"_id": 1})
 	}
+	d.ids.apply(query, "_id")
 
 	var docs []model.ProjectVars
 	if limitStr := os.Getenv(projectVarsLimitEnvVar); limitStr != "" {
@@ -0,0 +1,17 @@
+package migrations
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDeleteProjectVars(t *testing.T) {
+	t.Run("DeletesAllVars", func(t *testing.T) {
+		runGoldenTest(t, deleteProjectVarsName, "all", MigrationOptions{})
+	})
+	t.Run("SelectedIDs", func(t *testing.T) {
+		t.Setenv(includeIDsFileEnvVar, filepath.Join("testdata", deleteProjectVarsName, "selected", "ids.txt"))
+		t.Setenv(excludeIDsEnvVar, "project2")
+		runGoldenTest(t, deleteProjectVarsName, "selected", MigrationOptions{})
+	})
+}
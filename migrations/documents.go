@@ -0,0 +1,92 @@
+package migrations
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// equalDocuments returns whether the documents have the same fields with the
+// same values, regardless of the order of their fields, including in embedded
+// documents. The order of array elements still matters. If anyNumberType is
+// set, numbers of different types are equal if they have the same value.
+func equalDocuments(a, b bson.Raw, anyNumberType bool) (bool, error) {
+	aElems, err := a.Elements()
+	if err != nil {
+		return false, errors.Wrap(err, "reading document")
+	}
+	bElems, err := b.Elements()
+	if err != nil {
+		return false, errors.Wrap(err, "reading document")
+	}
+	if len(aElems) != len(bElems) {
+		return false, nil
+	}
+
+	bValues := make(map[string]bson.RawValue, len(bElems))
+	for _, elem := range bElems {
+		bValues[elem.Key()] = elem.Value()
+	}
+	for _, elem := range aElems {
+		bValue, ok := bValues[elem.Key()]
+		if !ok {
+			return false, nil
+		}
+		equal, err := equalValues(elem.Value(), bValue, anyNumberType)
+		if err != nil || !equal {
+			return false, errors.Wrapf(err, "comparing field '%s'", elem.Key())
+		}
+	}
+	return true, nil
+}
+
+func equalValues(a, b bson.RawValue, anyNumberType bool) (bool, error) {
+	if anyNumberType && isNumber(a.Type) && isNumber(b.Type) {
+		if a.Type != bson.TypeDouble && b.Type != bson.TypeDouble {
+			return a.AsInt64() == b.AsInt64(), nil
+		}
+		return numberAsFloat64(a) == numberAsFloat64(b), nil
+	}
+	if a.Type != b.Type {
+		return false, nil
+	}
+
+	switch a.Type {
+	case bson.TypeEmbeddedDocument:
+		return equalDocuments(a.Document(), b.Document(), anyNumberType)
+	case bson.TypeArray:
+		aValues, err := a.Array().Values()
+		if err != nil {
+			return false, errors.Wrap(err, "reading array")
+		}
+		bValues, err := b.Array().Values()
+		if err != nil {
+			return false, errors.Wrap(err, "reading array")
+		}
+		if len(aValues) != len(bValues) {
+			return false, nil
+		}
+		for i := range aValues {
+			equal, err := equalValues(aValues[i], bValues[i], anyNumberType)
+			if err != nil || !equal {
+				return false, errors.Wrapf(err, "comparing array element %d", i)
+			}
+		}
+		return true, nil
+	default:
+		return bytes.Equal(a.Value, b.Value), nil
+	}
+}
+
+func isNumber(t bsontype.Type) bool {
+	return t == bson.TypeInt32 || t == bson.TypeInt64 || t == bson.TypeDouble
+}
+
+func numberAsFloat64(v bson.RawValue) float64 {
+	if v.Type == bson.TypeDouble {
+		return v.Double()
+	}
+	return float64(v.AsInt64())
+}
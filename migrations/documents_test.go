@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestEqualDocuments(t *testing.T) {
+	marshal := func(doc interface{}) bson.Raw {
+		raw, err := bson.Marshal(doc)
+		require.NoError(t, err)
+		return raw
+	}
+	doc := marshal(bson.D{
+		{Key: "a", Value: int32(1)},
+		{Key: "b", Value: bson.D{{Key: "c", Value: "x"}, {Key: "d", Value: bson.A{int32(1), int32(2)}}}},
+	})
+
+	for name, test := range map[string]struct {
+		other         bson.Raw
+		anyNumberType bool
+		expected      bool
+	}{
+		"Identical": {
+			other:    doc,
+			expected: true,
+		},
+		"DifferentFieldOrder": {
+			other: marshal(bson.D{
+				{Key: "b", Value: bson.D{{Key: "d", Value: bson.A{int32(1), int32(2)}}, {Key: "c", Value: "x"}}},
+				{Key: "a", Value: int32(1)},
+			}),
+			expected: true,
+		},
+		"DifferentArrayOrder": {
+			other: marshal(bson.D{
+				{Key: "a", Value: int32(1)},
+				{Key: "b", Value: bson.D{{Key: "c", Value: "x"}, {Key: "d", Value: bson.A{int32(2), int32(1)}}}},
+			}),
+		},
+		"DifferentValue": {
+			other: marshal(bson.D{
+				{Key: "a", Value: int32(1)},
+				{Key: "b", Value: bson.D{{Key: "c", Value: "y"}, {Key: "d", Value: bson.A{int32(1), int32(2)}}}},
+			}),
+		},
+		"ExtraField": {
+			other: marshal(bson.D{
+				{Key: "a", Value: int32(1)},
+				{Key: "b", Value: bson.D{{Key: "c", Value: "x"}, {Key: "d", Value: bson.A{int32(1), int32(2)}}}},
+				{Key: "e", Value: true},
+			}),
+		},
+		"DifferentNumberType": {
+			other: marshal(bson.D{
+				{Key: "a", Value: 1.0},
+				{Key: "b", Value: bson.D{{Key: "c", Value: "x"}, {Key: "d", Value: bson.A{int64(1), int32(2)}}}},
+			}),
+		},
+		"AnyNumberType": {
+			other: marshal(bson.D{
+				{Key: "a", Value: 1.0},
+				{Key: "b", Value: bson.D{{Key: "c", Value: "x"}, {Key: "d", Value: bson.A{int64(1), int32(2)}}}},
+			}),
+			anyNumberType: true,
+			expected:      true,
+		},
+		"AnyNumberTypeDifferentValue": {
+			other: marshal(bson.D{
+				{Key: "a", Value: 1.5},
+				{Key: "b", Value: bson.D{{Key: "c", Value: "x"}, {Key: "d", Value: bson.A{int64(1), int32(2)}}}},
+			}),
+			anyNumberType: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			equal, err := equalDocuments(doc, test.other, test.anyNumberType)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, equal)
+		})
+	}
+}
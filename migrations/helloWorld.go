@@ -1,53 +1,360 @@
 package migrations
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 const (
 	helloWorld = "hello-world"
+
+	defaultInspectSampleSize  = 100
+	defaultInspectExamples    = 3
+	inspectFormatText         = "text"
+	inspectFormatJSON         = "json"
+	inspectExampleMaxLength   = 60
+	inspectArrayElementsField = "[]"
+
+	// inspectSampleSizeEnvVar is the number of documents to sample to infer
+	// the collection's fields. It defaults to 100.
+	inspectSampleSizeEnvVar = "INSPECT_SAMPLE_SIZE"
+	// inspectExamplesEnvVar is the most distinct example values to show for
+	// each field. It defaults to 3.
+	inspectExamplesEnvVar = "INSPECT_EXAMPLES"
+	// inspectFormatEnvVar is the output format, 'text' or 'json'. It
+	// defaults to 'text'.
+	inspectFormatEnvVar = "INSPECT_FORMAT"
 )
 
 func init() {
-	Registry.registerMigration(helloWorld, newHelloWorld)
+	Registry.registerMigration(helloWorld, newHelloWorld, withRisk(RiskReadOnly))
 }
 
-// hello connects to the database and prints the result of a findOne on the specified collection.
+// hello connects to the database and prints a report on the specified
+// collection: its stats, its indexes and the fields inferred from a sample of
+// its documents.
 type hello struct {
 	database   string
 	collection string
+	sampleSize int
+	examples   int
+	format     string
+	out        io.Writer
 }
 
 func newHelloWorld(opts MigrationOptions) (Migration, error) {
-	return &hello{
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+	catcher.NewWhen(opts.Collection == "", "collection name not specified")
+
+	h := &hello{
 		database:   opts.Database,
 		collection: opts.Collection,
-	}, nil
+		sampleSize: defaultInspectSampleSize,
+		examples:   defaultInspectExamples,
+		format:     inspectFormatText,
+		out:        os.Stdout,
+	}
+	if sizeStr := os.Getenv(inspectSampleSizeEnvVar); sizeStr != "" {
+		size, err := strconv.Atoi(sizeStr)
+		catcher.Wrapf(err, "parsing '%s'", inspectSampleSizeEnvVar)
+		catcher.ErrorfWhen(err == nil && size <= 0, "'%s' must be positive", inspectSampleSizeEnvVar)
+		h.sampleSize = size
+	}
+	if examplesStr := os.Getenv(inspectExamplesEnvVar); examplesStr != "" {
+		examples, err := strconv.Atoi(examplesStr)
+		catcher.Wrapf(err, "parsing '%s'", inspectExamplesEnvVar)
+		catcher.ErrorfWhen(err == nil && examples < 0, "'%s' can't be negative", inspectExamplesEnvVar)
+		h.examples = examples
+	}
+	if format := os.Getenv(inspectFormatEnvVar); format != "" {
+		h.format = format
+	}
+	catcher.ErrorfWhen(h.format != inspectFormatText && h.format != inspectFormatJSON, "'%s' must be '%s' or '%s'", inspectFormatEnvVar, inspectFormatText, inspectFormatJSON)
+
+	return h, catcher.Resolve()
+}
+
+// collectionReport describes a collection and the fields of its documents.
+type collectionReport struct {
+	Database   string          `json:"database"`
+	Collection string          `json:"collection"`
+	Stats      collectionStats `json:"stats"`
+	Indexes    []indexReport   `json:"indexes"`
+	SampleSize int             `json:"sample_size"`
+	Fields     []*fieldReport  `json:"fields"`
+}
+
+// collectionStats are the storage stats of a collection, summed across
+// shards.
+type collectionStats struct {
+	Documents      int64 `json:"documents" bson:"count"`
+	Size           int64 `json:"size" bson:"size"`
+	StorageSize    int64 `json:"storage_size" bson:"storageSize"`
+	TotalIndexSize int64 `json:"total_index_size" bson:"totalIndexSize"`
+}
+
+// indexReport describes an index.
+type indexReport struct {
+	Name string `json:"name"`
+	// Keys is the extended JSON of the index's keys.
+	Keys               string `json:"keys"`
+	Unique             bool   `json:"unique,omitempty"`
+	Sparse             bool   `json:"sparse,omitempty"`
+	ExpireAfterSeconds *int64 `json:"expire_after_seconds,omitempty"`
+}
+
+// fieldReport describes a field observed in the sampled documents. The
+// elements of arrays are described by a nested field named "[]".
+type fieldReport struct {
+	Name string `json:"name"`
+	// Types is the number of times the field was seen with each BSON type.
+	Types map[string]int `json:"types"`
+	// Presence is the percentage of the sampled documents that have the
+	// field.
+	Presence float64 `json:"presence"`
+	// Examples are distinct values of the field as relaxed extended JSON.
+	Examples []string       `json:"examples,omitempty"`
+	Fields   []*fieldReport `json:"fields,omitempty"`
+
+	// documents is the number of sampled documents that have the field, and
+	// lastDocument is the last of them, so a field that appears several times
+	// in one document through arrays is only counted once.
+	documents    int
+	lastDocument int
+	fieldsByName map[string]*fieldReport
 }
 
+// Execute prints the report on the collection.
 func (h *hello) Execute(ctx context.Context, client *mongo.Client) error {
-	res := client.Database(h.database).Collection(h.collection).FindOne(ctx, bson.M{})
-	doc, err := res.Raw()
+	coll := client.Database(h.database).Collection(h.collection)
+	report := &collectionReport{
+		Database:   h.database,
+		Collection: h.collection,
+	}
+
+	stats, err := getCollectionStats(ctx, coll)
+	if err != nil {
+		return errors.Wrapf(err, "getting stats for collection '%s'", h.collection)
+	}
+	report.Stats = *stats
+	if report.Indexes, err = listIndexReports(ctx, coll); err != nil {
+		return errors.Wrapf(err, "listing indexes for collection '%s'", h.collection)
+	}
+
+	cur, err := coll.Aggregate(ctx, bson.A{bson.M{"$sample": bson.M{"size": h.sampleSize}}})
 	if err != nil {
-		return fmt.Errorf("finding document in collection '%s': %w", h.collection, err)
+		return errors.Wrapf(err, "sampling documents in collection '%s'", h.collection)
 	}
+	var docs []bson.Raw
+	if err := cur.All(ctx, &docs); err != nil {
+		return errors.Wrapf(err, "iterating over sampled documents in collection '%s'", h.collection)
+	}
+	report.SampleSize = len(docs)
+	report.Fields = inferFields(docs, h.examples)
+
+	return errors.Wrap(h.print(report), "printing report")
+}
 
-	jsonString, err := bson.MarshalExtJSON(doc, false, false)
+// getCollectionStats returns the collection's storage stats.
+func getCollectionStats(ctx context.Context, coll *mongo.Collection) (*collectionStats, error) {
+	cur, err := coll.Aggregate(ctx, bson.A{bson.M{"$collStats": bson.M{"storageStats": bson.M{}}}})
 	if err != nil {
-		return fmt.Errorf("marshalling document to json: %w", err)
+		return nil, err
+	}
+	var results []struct {
+		StorageStats collectionStats `bson:"storageStats"`
+	}
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
 	}
 
-	var jsonBuffer bytes.Buffer
-	if err := json.Indent(&jsonBuffer, jsonString, "", "  "); err != nil {
-		return fmt.Errorf("pretty printing json output: %w", err)
+	// Sharded collections have a result for each shard.
+	stats := &collectionStats{}
+	for _, result := range results {
+		stats.Documents += result.StorageStats.Documents
+		stats.Size += result.StorageStats.Size
+		stats.StorageSize += result.StorageStats.StorageSize
+		stats.TotalIndexSize += result.StorageStats.TotalIndexSize
 	}
+	return stats, nil
+}
 
-	fmt.Println(jsonBuffer.String())
-	return nil
+// listIndexReports describes the collection's indexes.
+func listIndexReports(ctx context.Context, coll *mongo.Collection) ([]indexReport, error) {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []struct {
+		Name               string   `bson:"name"`
+		Key                bson.Raw `bson:"key"`
+		Unique             bool     `bson:"unique"`
+		Sparse             bool     `bson:"sparse"`
+		ExpireAfterSeconds *int64   `bson:"expireAfterSeconds"`
+	}
+	if err := cur.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+
+	reports := make([]indexReport, 0, len(indexes))
+	for _, idx := range indexes {
+		keys, err := bson.MarshalExtJSON(idx.Key, false, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshalling keys of index '%s'", idx.Name)
+		}
+		reports = append(reports, indexReport{
+			Name:               idx.Name,
+			Keys:               string(keys),
+			Unique:             idx.Unique,
+			Sparse:             idx.Sparse,
+			ExpireAfterSeconds: idx.ExpireAfterSeconds,
+		})
+	}
+	return reports, nil
+}
+
+// inferFields returns the fields observed in the documents, in the order
+// they were first seen, with up to maxExamples example values each.
+func inferFields(docs []bson.Raw, maxExamples int) []*fieldReport {
+	root := &fieldReport{}
+	for i, doc := range docs {
+		addDocumentFields(root, doc, i+1, maxExamples)
+	}
+	finishFields(root.Fields, len(docs))
+	return root.Fields
+}
+
+// addDocumentFields records the fields of the document, which is the nth
+// sampled document, as children of the parent.
+func addDocumentFields(parent *fieldReport, doc bson.Raw, n, maxExamples int) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return
+	}
+	for _, elem := range elems {
+		addValue(parent.child(elem.Key()), elem.Value(), n, maxExamples)
+	}
+}
+
+// addValue records a value of the field seen in the nth sampled document.
+func addValue(field *fieldReport, value bson.RawValue, n, maxExamples int) {
+	if field.lastDocument != n {
+		field.documents++
+		field.lastDocument = n
+	}
+	field.Types[value.Type.String()]++
+
+	switch value.Type {
+	case bson.TypeEmbeddedDocument:
+		addDocumentFields(field, value.Document(), n, maxExamples)
+	case bson.TypeArray:
+		values, err := value.Array().Values()
+		if err != nil {
+			return
+		}
+		elements := field.child(inspectArrayElementsField)
+		for _, elem := range values {
+			addValue(elements, elem, n, maxExamples)
+		}
+	default:
+		if len(field.Examples) >= maxExamples {
+			return
+		}
+		example := value.String()
+		if len(example) > inspectExampleMaxLength {
+			example = example[:inspectExampleMaxLength] + "..."
+		}
+		for _, existing := range field.Examples {
+			if existing == example {
+				return
+			}
+		}
+		field.Examples = append(field.Examples, example)
+	}
+}
+
+// child returns the field's child with the name, adding it if it hasn't been
+// seen yet.
+func (f *fieldReport) child(name string) *fieldReport {
+	if f.fieldsByName == nil {
+		f.fieldsByName = map[string]*fieldReport{}
+	}
+	if child, ok := f.fieldsByName[name]; ok {
+		return child
+	}
+	child := &fieldReport{Name: name, Types: map[string]int{}}
+	f.fieldsByName[name] = child
+	f.Fields = append(f.Fields, child)
+	return child
+}
+
+// finishFields sets the presence of the fields and their children.
+func finishFields(fields []*fieldReport, total int) {
+	for _, field := range fields {
+		if total > 0 {
+			field.Presence = 100 * float64(field.documents) / float64(total)
+		}
+		finishFields(field.Fields, total)
+	}
+}
+
+func (h *hello) print(report *collectionReport) error {
+	if h.format == inspectFormatJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshalling report to JSON")
+		}
+		_, err = fmt.Fprintln(h.out, string(data))
+		return err
+	}
+
+	w := tabwriter.NewWriter(h.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Collection %s.%s: %d documents, %d bytes (%d bytes stored), %d bytes of indexes\n",
+		report.Database, report.Collection, report.Stats.Documents, report.Stats.Size, report.Stats.StorageSize, report.Stats.TotalIndexSize)
+
+	fmt.Fprintln(w, "\nIndexes:")
+	for _, idx := range report.Indexes {
+		var opts []string
+		if idx.Unique {
+			opts = append(opts, "unique")
+		}
+		if idx.Sparse {
+			opts = append(opts, "sparse")
+		}
+		if idx.ExpireAfterSeconds != nil {
+			opts = append(opts, fmt.Sprintf("expires after %ds", *idx.ExpireAfterSeconds))
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", idx.Name, idx.Keys, strings.Join(opts, ", "))
+	}
+
+	fmt.Fprintf(w, "\nFields in %d sampled documents:\n", report.SampleSize)
+	printFields(w, report.Fields, 1)
+	return w.Flush()
+}
+
+// printFields prints a line for each field, indenting children under their
+// parents.
+func printFields(w io.Writer, fields []*fieldReport, depth int) {
+	for _, field := range fields {
+		types := make([]string, 0, len(field.Types))
+		for name, count := range field.Types {
+			types = append(types, fmt.Sprintf("%s(%d)", name, count))
+		}
+		sort.Strings(types)
+		fmt.Fprintf(w, "%s%s\t%.1f%%\t%s\t%s\n", strings.Repeat("  ", depth), field.Name, field.Presence, strings.Join(types, " "), strings.Join(field.Examples, ", "))
+		printFields(w, field.Fields, depth+1)
+	}
 }
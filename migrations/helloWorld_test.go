@@ -0,0 +1,135 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestInferFields(t *testing.T) {
+	var docs []bson.Raw
+	for _, doc := range []bson.M{
+		{"_id": "t1", "execution": int32(0), "details": bson.M{"type": "test"}, "tags": bson.A{"a", "b"}},
+		{"_id": "t2", "execution": int64(1), "tags": bson.A{"a", int32(3)}},
+		{"_id": "t3", "execution": int32(0)},
+		{"_id": "t4", "execution": int32(2), "details": nil},
+	} {
+		raw, err := bson.Marshal(doc)
+		require.NoError(t, err)
+		docs = append(docs, raw)
+	}
+
+	fields := map[string]*fieldReport{}
+	var collect func(prefix string, reports []*fieldReport)
+	collect = func(prefix string, reports []*fieldReport) {
+		for _, report := range reports {
+			fields[prefix+report.Name] = report
+			collect(prefix+report.Name+".", report.Fields)
+		}
+	}
+	collect("", inferFields(docs, 2))
+
+	require.Contains(t, fields, "_id")
+	assert.Equal(t, 100.0, fields["_id"].Presence)
+	assert.Equal(t, map[string]int{"string": 4}, fields["_id"].Types)
+	assert.Equal(t, []string{`"t1"`, `"t2"`}, fields["_id"].Examples, "examples should be limited")
+
+	require.Contains(t, fields, "execution")
+	assert.Equal(t, map[string]int{"32-bit integer": 3, "64-bit integer": 1}, fields["execution"].Types)
+	assert.Len(t, fields["execution"].Examples, 2, "examples should be distinct")
+
+	require.Contains(t, fields, "details")
+	assert.Equal(t, 50.0, fields["details"].Presence)
+	assert.Equal(t, map[string]int{"embedded document": 1, "null": 1}, fields["details"].Types)
+	require.Contains(t, fields, "details.type")
+	assert.Equal(t, 25.0, fields["details.type"].Presence)
+
+	require.Contains(t, fields, "tags.[]")
+	assert.Equal(t, 50.0, fields["tags"].Presence)
+	assert.Equal(t, 50.0, fields["tags.[]"].Presence, "array elements should be counted once per document")
+	assert.Equal(t, map[string]int{"string": 3, "32-bit integer": 1}, fields["tags.[]"].Types)
+}
+
+func TestNewHelloWorld(t *testing.T) {
+	opts := MigrationOptions{Database: "db", Collection: "tasks"}
+
+	t.Run("MissingCollection", func(t *testing.T) {
+		_, err := newHelloWorld(MigrationOptions{Database: "db"})
+		assert.Error(t, err)
+	})
+	t.Run("InvalidFormat", func(t *testing.T) {
+		t.Setenv(inspectFormatEnvVar, "yaml")
+		_, err := newHelloWorld(opts)
+		assert.Error(t, err)
+	})
+	t.Run("InvalidSampleSize", func(t *testing.T) {
+		t.Setenv(inspectSampleSizeEnvVar, "0")
+		_, err := newHelloWorld(opts)
+		assert.Error(t, err)
+	})
+	t.Run("Defaults", func(t *testing.T) {
+		migration, err := newHelloWorld(opts)
+		require.NoError(t, err)
+		assert.Equal(t, defaultInspectSampleSize, migration.(*hello).sampleSize)
+		assert.Equal(t, inspectFormatText, migration.(*hello).format)
+	})
+}
+
+func TestHelloWorld(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(ctx))
+	}()
+	coll := client.Database(db).Collection("tasks")
+	_, err := coll.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "t1", "status": "success"},
+		bson.M{"_id": "t2", "status": "failed", "execution": 1},
+	})
+	require.NoError(t, err)
+	_, err = coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}}})
+	require.NoError(t, err)
+
+	run := func(t *testing.T, collection string) (*bytes.Buffer, error) {
+		migration, err := newHelloWorld(MigrationOptions{Database: db, Collection: collection})
+		require.NoError(t, err)
+		var out bytes.Buffer
+		migration.(*hello).out = &out
+		return &out, migration.Execute(ctx, client)
+	}
+
+	t.Run("Text", func(t *testing.T) {
+		out, err := run(t, "tasks")
+		require.NoError(t, err)
+		assert.Contains(t, out.String(), "2 documents")
+		assert.Contains(t, out.String(), "status_1")
+		assert.Contains(t, out.String(), "execution")
+	})
+	t.Run("JSON", func(t *testing.T) {
+		t.Setenv(inspectFormatEnvVar, inspectFormatJSON)
+		out, err := run(t, "tasks")
+		require.NoError(t, err)
+
+		var report collectionReport
+		require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+		assert.EqualValues(t, 2, report.Stats.Documents)
+		assert.Equal(t, 2, report.SampleSize)
+		assert.Len(t, report.Indexes, 2)
+		require.Len(t, report.Fields, 3)
+		assert.Equal(t, "_id", report.Fields[0].Name)
+	})
+	t.Run("Empty", func(t *testing.T) {
+		require.NoError(t, client.Database(db).CreateCollection(ctx, "empty"))
+		out, err := run(t, "empty")
+		require.NoError(t, err)
+		assert.Contains(t, out.String(), "Fields in 0 sampled documents")
+	})
+}
@@ -0,0 +1,122 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RunHistoryCollection is the collection in the target database that records
+// the migrations run against it.
+const RunHistoryCollection = "migration_runs"
+
+// RunStatus is the outcome of a migration run.
+type RunStatus string
+
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusSucceeded RunStatus = "succeeded"
+	RunStatusFailed    RunStatus = "failed"
+)
+
+// RunRecord is a migration run recorded in the run history.
+type RunRecord struct {
+	ID       primitive.ObjectID `bson:"_id"`
+	Script   string             `bson:"script"`
+	Database string             `bson:"database"`
+	// Plan and Step identify the plan step the migration was run for, if
+	// any.
+	Plan       string    `bson:"plan,omitempty"`
+	Step       string    `bson:"step,omitempty"`
+	Status     RunStatus `bson:"status"`
+	StartedAt  time.Time `bson:"started_at"`
+	FinishedAt time.Time `bson:"finished_at,omitempty"`
+	Error      string    `bson:"error,omitempty"`
+}
+
+const (
+	runRecordIDKey         = "_id"
+	runRecordScriptKey     = "script"
+	runRecordPlanKey       = "plan"
+	runRecordStepKey       = "step"
+	runRecordStatusKey     = "status"
+	runRecordFinishedAtKey = "finished_at"
+	runRecordErrorKey      = "error"
+)
+
+// RunHistory is the record of the migrations run against a database.
+type RunHistory struct {
+	coll  *mongo.Collection
+	clock Clock
+}
+
+// NewRunHistory returns the run history stored in the database.
+func NewRunHistory(db *mongo.Database, clock Clock) *RunHistory {
+	if clock == nil {
+		clock = realClock{}
+	}
+	return &RunHistory{
+		coll:  db.Collection(RunHistoryCollection),
+		clock: clock,
+	}
+}
+
+// Start records that a run has started.
+func (h *RunHistory) Start(ctx context.Context, record RunRecord) (*RunRecord, error) {
+	record.ID = primitive.NewObjectID()
+	record.Database = h.coll.Database().Name()
+	record.Status = RunStatusRunning
+	record.StartedAt = h.clock.Now()
+	if _, err := h.coll.InsertOne(ctx, record); err != nil {
+		return nil, errors.Wrap(err, "inserting run record")
+	}
+	return &record, nil
+}
+
+// Finish records the outcome of a run.
+func (h *RunHistory) Finish(ctx context.Context, record *RunRecord, runErr error) error {
+	record.FinishedAt = h.clock.Now()
+	record.Status = RunStatusSucceeded
+	if runErr != nil {
+		record.Status = RunStatusFailed
+		record.Error = runErr.Error()
+	}
+
+	_, err := h.coll.UpdateByID(ctx, record.ID, bson.M{"$set": bson.M{
+		runRecordStatusKey:     record.Status,
+		runRecordFinishedAtKey: record.FinishedAt,
+		runRecordErrorKey:      record.Error,
+	}})
+	return errors.Wrap(err, "updating run record")
+}
+
+// hasSucceeded returns whether a run matching the filter has succeeded.
+func (h *RunHistory) hasSucceeded(ctx context.Context, filter bson.M) (bool, error) {
+	query := bson.M{runRecordStatusKey: RunStatusSucceeded}
+	for key, val := range filter {
+		query[key] = val
+	}
+	err := h.coll.FindOne(ctx, query, options.FindOne().SetProjection(bson.M{runRecordIDKey: 1})).Err()
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "finding successful run")
+	}
+	return true, nil
+}
+
+// ScriptSucceeded returns whether the script has ever run successfully.
+func (h *RunHistory) ScriptSucceeded(ctx context.Context, script string) (bool, error) {
+	return h.hasSucceeded(ctx, bson.M{runRecordScriptKey: script})
+}
+
+// StepSucceeded returns whether the plan step has ever run successfully.
+func (h *RunHistory) StepSucceeded(ctx context.Context, plan, step string) (bool, error) {
+	return h.hasSucceeded(ctx, bson.M{runRecordPlanKey: plan, runRecordStepKey: step})
+}
@@ -0,0 +1,140 @@
+package migrations
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// includeIDsEnvVar is a comma-separated list of IDs to restrict a keyed
+	// script to.
+	includeIDsEnvVar = "INCLUDE_IDS"
+	// includeIDsFileEnvVar is the path to a file of IDs to restrict a keyed
+	// script to, one per line. Blank lines and lines starting with '#' are
+	// ignored.
+	includeIDsFileEnvVar = "INCLUDE_IDS_FILE"
+	// excludeIDsEnvVar is a comma-separated list of IDs for a keyed script to
+	// skip.
+	excludeIDsEnvVar = "EXCLUDE_IDS"
+	// excludeIDsFileEnvVar is the path to a file of IDs for a keyed script to
+	// skip, in the same format as the include file.
+	excludeIDsFileEnvVar = "EXCLUDE_IDS_FILE"
+)
+
+// idSelection is an explicit list of IDs to include and a list to exclude,
+// for scripts that process documents by ID. An empty include list includes
+// every ID. An ID that's in both lists is excluded.
+type idSelection struct {
+	include []string
+	exclude []string
+}
+
+// newIDSelection reads the IDs to include and exclude from the environment.
+// Each list is the union of the IDs in its variable and its file.
+func newIDSelection() (*idSelection, error) {
+	catcher := grip.NewBasicCatcher()
+	include, err := readIDList(includeIDsEnvVar, includeIDsFileEnvVar)
+	catcher.Wrap(err, "reading IDs to include")
+	exclude, err := readIDList(excludeIDsEnvVar, excludeIDsFileEnvVar)
+	catcher.Wrap(err, "reading IDs to exclude")
+
+	return &idSelection{include: include, exclude: exclude}, catcher.Resolve()
+}
+
+// readIDList returns the distinct IDs in the comma-separated list in the
+// environment variable and in the file named by the file environment
+// variable.
+func readIDList(listEnvVar, fileEnvVar string) ([]string, error) {
+	ids := splitFieldList(os.Getenv(listEnvVar))
+	if path := os.Getenv(fileEnvVar); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening '%s'", fileEnvVar)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			ids = append(ids, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, errors.Wrapf(err, "reading '%s'", fileEnvVar)
+		}
+	}
+
+	seen := map[string]bool{}
+	distinct := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			distinct = append(distinct, id)
+		}
+	}
+	return distinct, nil
+}
+
+// setIDSelectionEnvVars returns the ID selection environment variables that
+// are set.
+func setIDSelectionEnvVars() []string {
+	var set []string
+	for _, envVar := range []string{includeIDsEnvVar, includeIDsFileEnvVar, excludeIDsEnvVar, excludeIDsFileEnvVar} {
+		if os.Getenv(envVar) != "" {
+			set = append(set, envVar)
+		}
+	}
+	return set
+}
+
+// isEmpty returns whether the selection doesn't restrict any IDs.
+func (s *idSelection) isEmpty() bool {
+	return s == nil || (len(s.include) == 0 && len(s.exclude) == 0)
+}
+
+// apply restricts the query to the selected IDs in the key, keeping any
+// condition the query already has on the key, such as a lower bound to start
+// at. If the query already has its own $in or $nin on the key, both must
+// match, so the selection is added to the query's $and.
+func (s *idSelection) apply(query bson.M, key string) {
+	if s.isEmpty() {
+		return
+	}
+
+	selected := bson.M{}
+	if len(s.include) > 0 {
+		selected["$in"] = s.include
+	}
+	if len(s.exclude) > 0 {
+		selected["$nin"] = s.exclude
+	}
+
+	cond, ok := query[key].(bson.M)
+	if !ok {
+		cond = bson.M{}
+		if existing, exists := query[key]; exists {
+			cond["$eq"] = existing
+		}
+	}
+	for op := range selected {
+		if _, exists := cond[op]; exists {
+			and, ok := query["$and"].(bson.A)
+			if existing, exists := query["$and"]; exists && !ok {
+				and = bson.A{bson.M{"$and": existing}}
+			}
+			query["$and"] = append(and, bson.M{key: selected})
+			return
+		}
+	}
+	for op, ids := range selected {
+		cond[op] = ids
+	}
+	query[key] = cond
+}
@@ -0,0 +1,104 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestNewIDSelection(t *testing.T) {
+	t.Run("Empty", func(t *testing.T) {
+		ids, err := newIDSelection()
+		require.NoError(t, err)
+		assert.True(t, ids.isEmpty())
+	})
+	t.Run("ListAndFile", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "ids.txt")
+		require.NoError(t, os.WriteFile(path, []byte("# Projects to fix\nproject2\n\n  project3  \nproject1\n"), 0644))
+		t.Setenv(includeIDsEnvVar, "project1, project4")
+		t.Setenv(includeIDsFileEnvVar, path)
+		t.Setenv(excludeIDsEnvVar, "project5")
+
+		ids, err := newIDSelection()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"project1", "project4", "project2", "project3"}, ids.include, "IDs should be distinct")
+		assert.Equal(t, []string{"project5"}, ids.exclude)
+	})
+	t.Run("MissingFile", func(t *testing.T) {
+		t.Setenv(excludeIDsFileEnvVar, filepath.Join(t.TempDir(), "missing.txt"))
+		_, err := newIDSelection()
+		assert.Error(t, err)
+	})
+	t.Run("IncludedAndExcluded", func(t *testing.T) {
+		t.Setenv(includeIDsEnvVar, "project1,project2")
+		t.Setenv(excludeIDsEnvVar, "project2")
+		ids, err := newIDSelection()
+		require.NoError(t, err)
+		query := bson.M{}
+		ids.apply(query, "_id")
+		assert.Equal(t, bson.M{"_id": bson.M{"$in": []string{"project1", "project2"}, "$nin": []string{"project2"}}}, query, "excluded IDs should take precedence")
+	})
+}
+
+func TestIDSelectionApply(t *testing.T) {
+	ids := &idSelection{include: []string{"a", "b"}, exclude: []string{"c"}}
+
+	t.Run("NoCondition", func(t *testing.T) {
+		query := bson.M{"vars": bson.M{"$exists": true}}
+		ids.apply(query, "_id")
+		assert.Equal(t, bson.M{
+			"vars": bson.M{"$exists": true},
+			"_id":  bson.M{"$in": []string{"a", "b"}, "$nin": []string{"c"}},
+		}, query)
+	})
+	t.Run("ExistingCondition", func(t *testing.T) {
+		query := bson.M{"_id": bson.M{"$gte": "b"}}
+		ids.apply(query, "_id")
+		assert.Equal(t, bson.M{"_id": bson.M{"$gte": "b", "$in": []string{"a", "b"}, "$nin": []string{"c"}}}, query)
+	})
+	t.Run("ExistingValue", func(t *testing.T) {
+		query := bson.M{"_id": "a"}
+		ids.apply(query, "_id")
+		assert.Equal(t, bson.M{"_id": bson.M{"$eq": "a", "$in": []string{"a", "b"}, "$nin": []string{"c"}}}, query)
+	})
+	t.Run("ExistingIn", func(t *testing.T) {
+		query := bson.M{"_id": bson.M{"$in": bson.A{"b", "d"}}}
+		ids.apply(query, "_id")
+		assert.Equal(t, bson.M{
+			"_id":  bson.M{"$in": bson.A{"b", "d"}},
+			"$and": bson.A{bson.M{"_id": bson.M{"$in": []string{"a", "b"}, "$nin": []string{"c"}}}},
+		}, query, "both lists of IDs should have to match")
+	})
+	t.Run("ExistingNinAndAnd", func(t *testing.T) {
+		query := bson.M{"_id": bson.M{"$nin": bson.A{"d"}}, "$and": bson.A{bson.M{"x": 1}}}
+		ids.apply(query, "_id")
+		assert.Equal(t, bson.M{
+			"_id": bson.M{"$nin": bson.A{"d"}},
+			"$and": bson.A{
+				bson.M{"x": 1},
+				bson.M{"_id": bson.M{"$in": []string{"a", "b"}, "$nin": []string{"c"}}},
+			},
+		}, query)
+	})
+	t.Run("Empty", func(t *testing.T) {
+		query := bson.M{"_id": "a"}
+		(&idSelection{}).apply(query, "_id")
+		assert.Equal(t, bson.M{"_id": "a"}, query)
+	})
+}
+
+func TestIDSelectionUnsupported(t *testing.T) {
+	t.Setenv(includeIDsEnvVar, "t1")
+	t.Setenv(renameFieldsEnvVar, testFieldRenames)
+
+	_, err := Registry.Migration(renameFieldsName, MigrationOptions{Database: "db", Collection: "tasks"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "can't be restricted to a list of IDs, but INCLUDE_IDS is set")
+
+	_, err = Registry.Migration(deleteProjectVarsName, MigrationOptions{Database: "db"})
+	assert.NoError(t, err)
+}
@@ -2,6 +2,8 @@ package migrations
 
 import (
 	"context"
+	"sort"
+	"strings"
 
 	"github.com/mongodb/grip"
 	"github.com/pkg/errors"
@@ -11,22 +13,146 @@ import (
 var Registry migrationRegistry
 
 type migrationRegistry struct {
-	migrations map[string]MigrationFactory
+	migrations map[string]registeredMigration
 }
 
-func (m *migrationRegistry) registerMigration(name string, factory MigrationFactory) {
+type registeredMigration struct {
+	factory MigrationFactory
+	info    MigrationInfo
+}
+
+// MigrationInfo describes a registered migration.
+type MigrationInfo struct {
+	Name string
+	// Risk is how much damage the migration can do if it's run by mistake.
+	Risk RiskLevel
+	// Prerequisites are the migrations that must have succeeded against the
+	// database before this migration runs.
+	Prerequisites []string
+	// Version is the migration's position in the schema's history. It's 0 for
+	// ad-hoc scripts, which aren't part of the schema's history.
+	Version int
+	// CreatesTarget is set for migrations that create the target database and
+	// collection, which then don't need to exist before they run.
+	CreatesTarget bool
+	// SelectsIDs is set for migrations that can be restricted to explicit
+	// lists of IDs. Other migrations refuse to run if the lists are set.
+	SelectsIDs bool
+}
+
+// RiskLevel is how much damage a migration can do if it's run by mistake.
+type RiskLevel string
+
+const (
+	// RiskReadOnly migrations don't modify the database.
+	RiskReadOnly RiskLevel = "read-only"
+	// RiskWrite migrations modify the database in ways that can be undone.
+	RiskWrite RiskLevel = "write"
+	// RiskDestructive migrations delete or overwrite data that can't be
+	// recovered. They must be explicitly confirmed before they run.
+	RiskDestructive RiskLevel = "destructive"
+)
+
+// registrationOption sets optional information about a migration when it's
+// registered.
+type registrationOption func(*MigrationInfo)
+
+// withRisk sets the migration's risk level. Migrations that don't declare a
+// risk level are treated as destructive.
+func withRisk(risk RiskLevel) registrationOption {
+	return func(info *MigrationInfo) {
+		info.Risk = risk
+	}
+}
+
+// withPrerequisites sets the migrations that must have succeeded against the
+// database before the migration runs.
+func withPrerequisites(names ...string) registrationOption {
+	return func(info *MigrationInfo) {
+		info.Prerequisites = append(info.Prerequisites, names...)
+	}
+}
+
+// withVersion makes the migration the given version of the schema. Versioned
+// migrations are applied in order by Up.
+func withVersion(version int) registrationOption {
+	return func(info *MigrationInfo) {
+		info.Version = version
+	}
+}
+
+// withCreatesTarget lets the migration run against a target database and
+// collection that don't exist yet, because it creates them.
+func withCreatesTarget() registrationOption {
+	return func(info *MigrationInfo) {
+		info.CreatesTarget = true
+	}
+}
+
+// withIDSelection declares that the migration restricts the documents it
+// processes to the IDs selected in the environment with newIDSelection.
+func withIDSelection() registrationOption {
+	return func(info *MigrationInfo) {
+		info.SelectsIDs = true
+	}
+}
+
+func (m *migrationRegistry) registerMigration(name string, factory MigrationFactory, opts ...registrationOption) {
 	if m.migrations == nil {
-		m.migrations = make(map[string]MigrationFactory)
+		m.migrations = make(map[string]registeredMigration)
+	}
+	info := MigrationInfo{
+		Name: name,
+		Risk: RiskDestructive,
+	}
+	for _, opt := range opts {
+		opt(&info)
+	}
+	m.migrations[name] = registeredMigration{
+		factory: factory,
+		info:    info,
 	}
-	m.migrations[name] = factory
 }
 
 func (m *migrationRegistry) Migration(name string, opts MigrationOptions) (Migration, error) {
-	migrationFactory, ok := m.migrations[name]
+	registered, ok := m.migrations[name]
 	if !ok {
 		return nil, errors.Errorf("no migration exists for name '%s'", name)
 	}
-	return migrationFactory(opts)
+	// Ignoring the lists would run the migration on every document.
+	if set := setIDSelectionEnvVars(); len(set) > 0 && !registered.info.SelectsIDs {
+		return nil, errors.Errorf("migration '%s' can't be restricted to a list of IDs, but %s is set", name, strings.Join(set, ", "))
+	}
+	return registered.factory(opts)
+}
+
+// Info returns the information the migration was registered with.
+func (m *migrationRegistry) Info(name string) (MigrationInfo, error) {
+	registered, ok := m.migrations[name]
+	if !ok {
+		return MigrationInfo{}, errors.Errorf("no migration exists for name '%s'", name)
+	}
+	return registered.info, nil
+}
+
+// Versioned returns the versioned migrations in version order. Versions must
+// be numbered consecutively from 1.
+func (m *migrationRegistry) Versioned() ([]MigrationInfo, error) {
+	var versioned []MigrationInfo
+	for _, registered := range m.migrations {
+		if registered.info.Version != 0 {
+			versioned = append(versioned, registered.info)
+		}
+	}
+	sort.Slice(versioned, func(i, j int) bool {
+		return versioned[i].Version < versioned[j].Version
+	})
+
+	catcher := grip.NewBasicCatcher()
+	for i, info := range versioned {
+		catcher.ErrorfWhen(info.Version != i+1, "migration '%s' has version %d, but versions must be numbered consecutively from 1", info.Name, info.Version)
+	}
+	return versioned, catcher.Resolve()
 }
 
 type Migration interface {
@@ -39,6 +165,16 @@ type MigrationOptions struct {
 	Database   string
 	Collection string
 	BatchSize  int
+	// Clock is the source of time for the migration. If it's not set, the
+	// system time is used.
+	Clock Clock
+}
+
+func (m *MigrationOptions) getClock() Clock {
+	if m.Clock == nil {
+		return realClock{}
+	}
+	return m.Clock
 }
 
 func (m *MigrationOptions) validate() error {
@@ -0,0 +1,259 @@
+package migrations
+
+import (
+	"context"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
+	"github.com/evergreen-ci/evergreen-migrations/migrations/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// restartTestInterruptions is the number of times each migration is
+	// interrupted at a random point when checking that it can be restarted.
+	restartTestInterruptions = 5
+)
+
+// restartTestCase describes how to run a registered migration when checking
+// that it can be rerun and restarted.
+type restartTestCase struct {
+	// fixture is the fixture directory, relative to testdata.
+	fixture string
+	opts    MigrationOptions
+	env     map[string]string
+	// newEnv, if set, returns environment variables that must be different
+	// for each scenario, such as temporary directories.
+	newEnv func(t *testing.T) map[string]string
+	// newClock, if set, returns the clock to use for a scenario against the
+	// database.
+	newClock func(db *mongo.Database) Clock
+}
+
+// restartTestCases has a case for every registered migration.
+var restartTestCases = map[string]restartTestCase{
+	helloWorld: {
+		fixture: path.Join(deleteProjectVarsName, "all"),
+		opts:    MigrationOptions{Collection: "project_vars"},
+	},
+	missingAnnotationCountName: {
+		fixture: missingAnnotationCountName,
+		newClock: func(*mongo.Database) Clock {
+			return testdata.NewFakeClock(time.Date(2024, 06, 30, 0, 0, 0, 0, time.UTC))
+		},
+	},
+	ttlMigrationName: {
+		fixture: path.Join(ttlMigrationName, "execute"),
+		opts:    MigrationOptions{Collection: "tasks", BatchSize: 1},
+		env: map[string]string{
+			goalTTLEnvVar:      "24h",
+			ttlDecrementEnvVar: "24h",
+			ttlFieldEnvVar:     "create_time",
+		},
+		newClock: func(db *mongo.Database) Clock {
+			coll := db.Collection("tasks")
+			clock := testdata.NewFakeClock(time.Date(2100, 01, 05, 0, 0, 0, 0, time.UTC))
+			clock.OnSleep = func(ctx context.Context, now time.Time) error {
+				ttl, err := getIndexTTL(ctx, coll, "create_time")
+				if err != nil {
+					return err
+				}
+				_, err = coll.DeleteMany(ctx, bson.M{"create_time": bson.M{"$lt": now.Add(-ttl)}})
+				return err
+			}
+			return clock
+		},
+	},
+	deleteGitHubAppKeysName: {
+		fixture: path.Join(deleteGitHubAppKeysName, "all"),
+	},
+	deleteProjectVarsName: {
+		fixture: path.Join(deleteProjectVarsName, "all"),
+	},
+	redactProjectEventSecretsName: {
+		fixture: path.Join(redactProjectEventSecretsName, "all"),
+	},
+	archiveCollectionName: {
+		fixture: path.Join(archiveCollectionName, "deleteOld"),
+		opts:    MigrationOptions{Collection: "tasks", BatchSize: 2},
+		env: map[string]string{
+			archiveFilterEnvVar:      testArchiveFilter,
+			archiveMaxFileSizeEnvVar: "150",
+			archiveDeleteEnvVar:      "true",
+		},
+		newEnv: func(t *testing.T) map[string]string {
+			return map[string]string{archiveDirEnvVar: t.TempDir()}
+		},
+	},
+	deleteOrphansName: {
+		fixture: path.Join(deleteOrphansName, "projectVars"),
+		opts:    MigrationOptions{Collection: "project_vars", BatchSize: 2},
+		env: map[string]string{
+			orphanParentsEnvVar: testOrphanParents,
+			orphanDeleteEnvVar:  "true",
+		},
+		newEnv: func(t *testing.T) map[string]string {
+			return map[string]string{orphanBackupDirEnvVar: t.TempDir()}
+		},
+	},
+	mergeDuplicatesName: {
+		fixture: path.Join(mergeDuplicatesName, "mergeArrays"),
+		opts:    MigrationOptions{Collection: "task_annotations", BatchSize: 1},
+		env: map[string]string{
+			duplicateKeysEnvVar:        testDuplicateKeys,
+			duplicateStrategyEnvVar:    duplicateStrategyMergeArrays,
+			duplicateMergeFieldsEnvVar: testDuplicateMergeFields,
+		},
+		newEnv: func(t *testing.T) map[string]string {
+			return map[string]string{duplicateBackupDirEnvVar: t.TempDir()}
+		},
+	},
+	applyValidatorName: {
+		fixture: path.Join(applyValidatorName, "tasks"),
+		opts:    MigrationOptions{Collection: "tasks"},
+		env: map[string]string{
+			validatorSchemaFileEnvVar: testValidatorSchemaFile,
+		},
+	},
+	manageIndexesName: {
+		fixture: path.Join(manageIndexesName, "tasks"),
+		env: map[string]string{
+			indexSpecFileEnvVar: testIndexSpecFile,
+		},
+	},
+	pruneEventsName: {
+		fixture: path.Join(pruneEventsName, "retention"),
+		opts:    MigrationOptions{BatchSize: 2},
+		env: map[string]string{
+			eventRetentionEnvVar: testEventRetention,
+		},
+		newEnv: func(t *testing.T) map[string]string {
+			return map[string]string{eventPruneArchiveDirEnvVar: t.TempDir()}
+		},
+		newClock: func(*mongo.Database) Clock {
+			return testdata.NewFakeClock(testEventPruneNow)
+		},
+	},
+	scanSecretsName: {
+		fixture: path.Join(scanSecretsName, "leaked"),
+		env: map[string]string{
+			secretScanTargetsEnvVar: testSecretScanTargets,
+		},
+	},
+	migrateProjectVarsName: {
+		fixture: path.Join(migrateProjectVarsName, "all"),
+		newEnv: func(t *testing.T) map[string]string {
+			return map[string]string{projectVarsSecretStoreEnvVar: "file://" + t.TempDir()}
+		},
+	},
+	backfillFieldName: {
+		fixture: path.Join(backfillFieldName, "expression"),
+		opts:    MigrationOptions{Collection: "tasks", BatchSize: 2},
+		env: map[string]string{
+			backfillFieldEnvVar:      testBackfillField,
+			backfillExpressionEnvVar: testBackfillExpression,
+		},
+	},
+	repairHasAnnotationsName: {
+		fixture: path.Join(repairHasAnnotationsName, "all"),
+		opts:    MigrationOptions{BatchSize: 2},
+		env: map[string]string{
+			annotationRepairProjectsEnvVar: testAnnotationRepairProjects,
+		},
+		newClock: func(*mongo.Database) Clock {
+			return testdata.NewFakeClock(testAnnotationRepairNow)
+		},
+	},
+	restoreCollectionName: {
+		fixture: path.Join(restoreCollectionName, restoreModeUpsert),
+		opts:    MigrationOptions{BatchSize: 2},
+		env: map[string]string{
+			restoreModeEnvVar: restoreModeUpsert,
+		},
+		newEnv: func(t *testing.T) map[string]string {
+			return map[string]string{restoreDirEnvVar: writeTestArchive(t, archive.FormatBSON)}
+		},
+	},
+	renameFieldsName: {
+		fixture: path.Join(renameFieldsName, "all"),
+		opts:    MigrationOptions{Collection: "tasks", BatchSize: 1},
+		env: map[string]string{
+			renameFieldsEnvVar: testFieldRenames,
+			renameFilterEnvVar: testRenameFilter,
+		},
+	},
+}
+
+// TestRegisteredMigrationsCanBeRestarted checks that every registered
+// migration is idempotent and reaches the same final state when it's
+// interrupted and restarted as when it runs uninterrupted.
+func TestRegisteredMigrationsCanBeRestarted(t *testing.T) {
+	names := make([]string, 0, len(Registry.migrations))
+	for name := range Registry.migrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			testCase, ok := restartTestCases[name]
+			require.True(t, ok, "migration '%s' has no restart test case", name)
+			for key, val := range testCase.env {
+				t.Setenv(key, val)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client, db := newTestClient(ctx, t)
+			test := testdata.RestartTest{
+				Dir:      path.Join("testdata", testCase.fixture),
+				Database: db,
+				Setup: func(t *testing.T, client *mongo.Client) testdata.RunFunc {
+					if testCase.newEnv != nil {
+						for key, val := range testCase.newEnv(t) {
+							t.Setenv(key, val)
+						}
+					}
+					opts := testCase.opts
+					opts.Database = db
+					if testCase.newClock != nil {
+						opts.Clock = testCase.newClock(client.Database(db))
+					}
+					// Create a new instance of the migration for each run, as
+					// a restarted migration would.
+					return func(ctx context.Context, client *mongo.Client) error {
+						migration, err := Registry.Migration(name, opts)
+						if err != nil {
+							return err
+						}
+						return migration.Execute(ctx, client)
+					}
+				},
+			}
+
+			t.Run("Idempotent", func(t *testing.T) {
+				testdata.CheckIdempotent(ctx, t, client, test)
+			})
+			t.Run("Interruptible", func(t *testing.T) {
+				testdata.CheckInterruptible(ctx, t, testServer.URI, test, restartTestInterruptions)
+			})
+		})
+	}
+}
+
+func TestPrerequisitesAreRegistered(t *testing.T) {
+	for name, registered := range Registry.migrations {
+		for _, prereq := range registered.info.Prerequisites {
+			_, ok := Registry.migrations[prereq]
+			assert.True(t, ok, "migration '%s' has unregistered prerequisite '%s'", name, prereq)
+			assert.NotEqual(t, name, prereq, "migration '%s' can't be its own prerequisite", name)
+		}
+	}
+}
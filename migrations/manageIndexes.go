@@ -0,0 +1,590 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	manageIndexesName          = "manageIndexes"
+	indexBuildPollInterval     = 10 * time.Second
+	indexStatePresent          = "present"
+	indexStateHidden           = "hidden"
+	indexStateAbsent           = "absent"
+	idIndexName                = "_id_"
+	explainIndexNameKey        = "indexName"
+	explainCollectionScanStage = "COLLSCAN"
+
+	// indexSpecFileEnvVar is the path to a YAML file with the index spec.
+	indexSpecFileEnvVar = "INDEX_SPEC_FILE"
+)
+
+func init() {
+	Registry.registerMigration(manageIndexesName, newManageIndexes, withRisk(RiskDestructive))
+}
+
+// indexSpec declares the indexes a collection should have, and sample
+// queries whose plans are checked before and after the indexes change:
+//
+//	collection: tasks
+//	indexes:
+//	  - name: status_1_create_time_-1
+//	    keys: {status: 1, create_time: -1}
+//	  - name: old_index
+//	    state: absent
+//	explain:
+//	  - filter: '{"status": "failed"}'
+//	    sort: '{"create_time": -1}'
+//	    expect_index: status_1_create_time_-1
+type indexSpec struct {
+	// Collection is the collection whose indexes are managed. It defaults
+	// to the --collection option.
+	Collection string            `yaml:"collection"`
+	Indexes    []indexDefinition `yaml:"indexes"`
+	Explain    []explainQuery    `yaml:"explain"`
+}
+
+// indexDefinition is the desired state of an index.
+type indexDefinition struct {
+	Name string `yaml:"name"`
+	// State is 'present', 'hidden' or 'absent'. It defaults to 'present'.
+	// A hidden index is maintained but isn't used by queries, so hiding an
+	// index before dropping it shows whether anything still needs it.
+	State string `yaml:"state"`
+	// Keys are the index keys in order. They're only needed for indexes
+	// that are present or hidden.
+	Keys               indexKeys `yaml:"keys"`
+	Unique             bool      `yaml:"unique"`
+	Sparse             bool      `yaml:"sparse"`
+	ExpireAfterSeconds *int32    `yaml:"expire_after_seconds"`
+	// PartialFilter is an extended JSON partial filter expression.
+	PartialFilter string `yaml:"partial_filter"`
+}
+
+// explainQuery is a sample query whose plan is checked.
+type explainQuery struct {
+	// Filter and Sort are extended JSON.
+	Filter string `yaml:"filter"`
+	Sort   string `yaml:"sort"`
+	// ExpectIndex, if set, is the index the query must use once the indexes
+	// have changed.
+	ExpectIndex string `yaml:"expect_index"`
+}
+
+// indexKeys are the keys of an index, in the order they're written in the
+// YAML mapping.
+type indexKeys bson.D
+
+// UnmarshalYAML decodes a mapping of fields to 1, -1 or an index type such
+// as 'text' or 'hashed', keeping the order of the fields.
+func (k *indexKeys) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.Errorf("line %d: index keys must be a mapping", node.Line)
+	}
+	keys := indexKeys{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		field, value := node.Content[i].Value, node.Content[i+1]
+		if direction, err := strconv.ParseInt(value.Value, 10, 32); err == nil && value.Tag == "!!int" {
+			keys = append(keys, bson.E{Key: field, Value: int32(direction)})
+		} else {
+			keys = append(keys, bson.E{Key: field, Value: value.Value})
+		}
+	}
+	*k = keys
+	return nil
+}
+
+// loadIndexSpec reads an index spec from a YAML file and validates it.
+func loadIndexSpec(path string) (*indexSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading index spec file '%s'", path)
+	}
+	spec := &indexSpec{}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, errors.Wrapf(err, "parsing index spec file '%s'", path)
+	}
+	for i := range spec.Indexes {
+		if spec.Indexes[i].State == "" {
+			spec.Indexes[i].State = indexStatePresent
+		}
+	}
+	return spec, errors.Wrapf(spec.validate(), "invalid index spec '%s'", path)
+}
+
+func (s *indexSpec) validate() error {
+	catcher := grip.NewBasicCatcher()
+	catcher.NewWhen(len(s.Indexes) == 0, "spec must have at least one index")
+
+	names := map[string]bool{}
+	for i, idx := range s.Indexes {
+		if idx.Name == "" {
+			catcher.Errorf("index %d has no name", i)
+			continue
+		}
+		catcher.ErrorfWhen(names[idx.Name], "index name '%s' is used more than once", idx.Name)
+		names[idx.Name] = true
+		catcher.ErrorfWhen(idx.Name == idIndexName, "index '%s' can't be managed", idIndexName)
+
+		switch idx.State {
+		case indexStatePresent, indexStateHidden:
+			catcher.ErrorfWhen(len(idx.Keys) == 0, "index '%s' has no keys", idx.Name)
+		case indexStateAbsent:
+		default:
+			catcher.Errorf("index '%s' has state '%s', which must be '%s', '%s' or '%s'", idx.Name, idx.State, indexStatePresent, indexStateHidden, indexStateAbsent)
+		}
+		if idx.PartialFilter != "" {
+			_, err := parseExtJSONDocument(idx.PartialFilter)
+			catcher.Wrapf(err, "parsing partial filter of index '%s'", idx.Name)
+		}
+	}
+
+	for i, query := range s.Explain {
+		_, err := parseExtJSONDocument(query.Filter)
+		catcher.Wrapf(err, "parsing filter of explain query %d", i)
+		_, err = parseExtJSONDocument(query.Sort)
+		catcher.Wrapf(err, "parsing sort of explain query %d", i)
+	}
+
+	return catcher.Resolve()
+}
+
+// parseExtJSONDocument parses an extended JSON document, which is empty if
+// the string is empty.
+func parseExtJSONDocument(doc string) (bson.D, error) {
+	parsed := bson.D{}
+	if doc == "" {
+		return parsed, nil
+	}
+	if err := bson.UnmarshalExtJSON([]byte(doc), false, &parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
+}
+
+// manageIndexes creates, hides, unhides and drops a collection's indexes to
+// match a spec.
+type manageIndexes struct {
+	database   string
+	collection string
+	spec       *indexSpec
+	clock      Clock
+}
+
+func newManageIndexes(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+
+	m := &manageIndexes{
+		database:   opts.Database,
+		collection: opts.Collection,
+		clock:      opts.getClock(),
+	}
+	specFile := os.Getenv(indexSpecFileEnvVar)
+	if specFile == "" {
+		catcher.Errorf("expected environment variable '%s' was not specified", indexSpecFileEnvVar)
+		return m, catcher.Resolve()
+	}
+	spec, err := loadIndexSpec(specFile)
+	catcher.Add(err)
+	m.spec = spec
+
+	if spec != nil && spec.Collection != "" {
+		catcher.ErrorfWhen(m.collection != "" && m.collection != spec.Collection, "collection '%s' doesn't match the spec's collection '%s'", m.collection, spec.Collection)
+		m.collection = spec.Collection
+	}
+	catcher.NewWhen(m.collection == "", "collection name not specified")
+
+	return m, catcher.Resolve()
+}
+
+// existingIndex is an index as listed by the server.
+type existingIndex struct {
+	Name                    string   `bson:"name"`
+	Key                     bson.Raw `bson:"key"`
+	Unique                  bool     `bson:"unique"`
+	Sparse                  bool     `bson:"sparse"`
+	Hidden                  bool     `bson:"hidden"`
+	ExpireAfterSeconds      *int64   `bson:"expireAfterSeconds"`
+	PartialFilterExpression bson.Raw `bson:"partialFilterExpression"`
+}
+
+// Execute explains the sample queries, brings each index to its declared
+// state and explains the queries again, checking that each uses its expected
+// index. An index build continues on the server if the script is interrupted,
+// so before building an index, the script waits for any build of it that's
+// already in progress.
+func (m *manageIndexes) Execute(ctx context.Context, client *mongo.Client) error {
+	db := client.Database(m.database)
+	coll := db.Collection(m.collection)
+
+	before, err := m.explainQueries(ctx, db)
+	if err != nil {
+		return errors.Wrap(err, "explaining queries before changing indexes")
+	}
+
+	for _, idx := range m.spec.Indexes {
+		if err := m.applyIndex(ctx, client, coll, idx); err != nil {
+			return errors.Wrapf(err, "applying index '%s' to collection '%s'", idx.Name, m.collection)
+		}
+	}
+
+	after, err := m.explainQueries(ctx, db)
+	if err != nil {
+		return errors.Wrap(err, "explaining queries after changing indexes")
+	}
+	catcher := grip.NewBasicCatcher()
+	for i, query := range m.spec.Explain {
+		grip.Infof("Query %d on collection '%s' used %s and now uses %s", i, m.collection, describePlanIndexes(before[i]), describePlanIndexes(after[i]))
+		if query.ExpectIndex == "" {
+			continue
+		}
+		catcher.ErrorfWhen(!containsString(after[i], query.ExpectIndex), "query %d uses %s instead of index '%s'", i, describePlanIndexes(after[i]), query.ExpectIndex)
+	}
+	return catcher.Resolve()
+}
+
+// Preflight requires the collection to exist. Changing indexes doesn't
+// modify any documents.
+func (m *manageIndexes) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	return &PreflightRequirements{Collections: []string{m.collection}}, nil
+}
+
+// applyIndex brings the index to its declared state.
+func (m *manageIndexes) applyIndex(ctx context.Context, client *mongo.Client, coll *mongo.Collection, idx indexDefinition) error {
+	if err := m.waitForIndexBuild(ctx, client, idx.Name); err != nil {
+		return errors.Wrap(err, "waiting for index build in progress")
+	}
+	existing, err := findIndex(ctx, coll, idx.Name)
+	if err != nil {
+		return errors.Wrap(err, "finding index")
+	}
+
+	if idx.State == indexStateAbsent {
+		if existing == nil {
+			grip.Infof("Index '%s' on collection '%s' is already absent", idx.Name, m.collection)
+			return nil
+		}
+		if _, err := coll.Indexes().DropOne(ctx, idx.Name); err != nil {
+			return errors.Wrap(err, "dropping index")
+		}
+		grip.Infof("Dropped index '%s' from collection '%s'", idx.Name, m.collection)
+		return nil
+	}
+
+	hidden := idx.State == indexStateHidden
+	if existing == nil {
+		return m.buildIndex(ctx, client, coll, idx, hidden)
+	}
+	if err := checkIndexMatches(existing, idx); err != nil {
+		return err
+	}
+	if existing.Hidden == hidden {
+		grip.Infof("Index '%s' on collection '%s' is already %s", idx.Name, m.collection, idx.State)
+		return nil
+	}
+	if err := coll.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: m.collection},
+		{Key: "index", Value: bson.M{"name": idx.Name, "hidden": hidden}},
+	}).Err(); err != nil {
+		return errors.Wrapf(err, "setting index hidden to %t", hidden)
+	}
+	grip.Infof("Set index '%s' on collection '%s' to %s", idx.Name, m.collection, idx.State)
+	return nil
+}
+
+// buildIndex creates the index, reporting the build's progress until it
+// finishes.
+func (m *manageIndexes) buildIndex(ctx context.Context, client *mongo.Client, coll *mongo.Collection, idx indexDefinition, hidden bool) error {
+	opts := options.Index().SetName(idx.Name)
+	if idx.Unique {
+		opts.SetUnique(true)
+	}
+	if idx.Sparse {
+		opts.SetSparse(true)
+	}
+	if hidden {
+		opts.SetHidden(true)
+	}
+	if idx.ExpireAfterSeconds != nil {
+		opts.SetExpireAfterSeconds(*idx.ExpireAfterSeconds)
+	}
+	if idx.PartialFilter != "" {
+		filter, err := parseExtJSONDocument(idx.PartialFilter)
+		if err != nil {
+			return errors.Wrap(err, "parsing partial filter")
+		}
+		opts.SetPartialFilterExpression(filter)
+	}
+
+	grip.Infof("Building index '%s' on collection '%s'", idx.Name, m.collection)
+	buildCtx, stopReporting := context.WithCancel(ctx)
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		for m.clock.Sleep(buildCtx, indexBuildPollInterval) == nil {
+			if _, err := m.reportIndexBuilds(buildCtx, client, idx.Name); err != nil && buildCtx.Err() == nil {
+				grip.Warningf("Couldn't get progress of index build '%s': %s", idx.Name, err)
+			}
+		}
+	}()
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D(idx.Keys), Options: opts})
+	stopReporting()
+	<-reported
+	if err != nil {
+		return errors.Wrap(err, "creating index")
+	}
+
+	grip.Infof("Built index '%s' on collection '%s'", idx.Name, m.collection)
+	return nil
+}
+
+// waitForIndexBuild waits until there are no builds of the index in
+// progress.
+func (m *manageIndexes) waitForIndexBuild(ctx context.Context, client *mongo.Client, name string) error {
+	for {
+		inProgress, err := m.reportIndexBuilds(ctx, client, name)
+		if err != nil {
+			return err
+		}
+		if !inProgress {
+			return nil
+		}
+		if err := m.clock.Sleep(ctx, indexBuildPollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// reportIndexBuilds logs the progress of the builds of the index that are in
+// progress and returns whether there are any.
+func (m *manageIndexes) reportIndexBuilds(ctx context.Context, client *mongo.Client, name string) (bool, error) {
+	var res struct {
+		InProgress []struct {
+			Msg      string `bson:"msg"`
+			Progress struct {
+				Done  int64 `bson:"done"`
+				Total int64 `bson:"total"`
+			} `bson:"progress"`
+		} `bson:"inprog"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "currentOp", Value: true},
+		{Key: "command.createIndexes", Value: m.collection},
+		{Key: "command.$db", Value: m.database},
+		{Key: "command.indexes.name", Value: name},
+	}).Decode(&res); err != nil {
+		return false, errors.Wrap(err, "getting current operations")
+	}
+
+	for _, op := range res.InProgress {
+		if op.Progress.Total > 0 {
+			grip.Infof("Index build '%s' on collection '%s' is in progress: %d/%d (%s)", name, m.collection, op.Progress.Done, op.Progress.Total, op.Msg)
+		} else {
+			grip.Infof("Index build '%s' on collection '%s' is in progress: %s", name, m.collection, op.Msg)
+		}
+	}
+	return len(res.InProgress) > 0, nil
+}
+
+// findIndex returns the index with the name, or nil if there isn't one.
+func findIndex(ctx context.Context, coll *mongo.Collection, name string) (*existingIndex, error) {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []existingIndex
+	if err := cur.All(ctx, &indexes); err != nil {
+		return nil, err
+	}
+	for _, idx := range indexes {
+		if idx.Name == name {
+			return &idx, nil
+		}
+	}
+	return nil, nil
+}
+
+// checkIndexMatches checks that the existing index has the declared keys and
+// options. An index can't be changed in place, so a mismatch must be resolved
+// by dropping the index or giving the new one a different name.
+func checkIndexMatches(existing *existingIndex, idx indexDefinition) error {
+	catcher := grip.NewBasicCatcher()
+	catcher.ErrorfWhen(!sameIndexKeys(existing.Key, bson.D(idx.Keys)), "exists with keys %s", existing.Key)
+	catcher.ErrorfWhen(existing.Unique != idx.Unique, "exists with unique %t", existing.Unique)
+	catcher.ErrorfWhen(existing.Sparse != idx.Sparse, "exists with sparse %t", existing.Sparse)
+	sameTTL := (existing.ExpireAfterSeconds == nil) == (idx.ExpireAfterSeconds == nil) &&
+		(existing.ExpireAfterSeconds == nil || *existing.ExpireAfterSeconds == int64(*idx.ExpireAfterSeconds))
+	catcher.NewWhen(!sameTTL, "exists with a different TTL")
+	if idx.PartialFilter != "" || existing.PartialFilterExpression != nil {
+		filter, err := parseExtJSONDocument(idx.PartialFilter)
+		catcher.Add(err)
+		if err == nil {
+			catcher.Add(checkPartialFilterMatches(existing.PartialFilterExpression, filter))
+		}
+	}
+	return errors.Wrap(catcher.Resolve(), "index doesn't match the spec")
+}
+
+// checkPartialFilterMatches checks that the existing partial filter is
+// equivalent to the declared one, regardless of the order of their fields or
+// the types of their numbers.
+func checkPartialFilterMatches(existing bson.Raw, filter bson.D) error {
+	if existing == nil {
+		return errors.New("exists without a partial filter")
+	}
+	want, err := bson.Marshal(filter)
+	if err != nil {
+		return errors.Wrap(err, "marshalling partial filter")
+	}
+	equal, err := equalDocuments(want, existing, true)
+	if err != nil {
+		return errors.Wrap(err, "comparing partial filters")
+	}
+	if !equal {
+		return errors.Errorf("exists with partial filter %s", existing)
+	}
+	return nil
+}
+
+// sameIndexKeys returns whether the existing keys are the same fields in the
+// same order with the same directions or types as the declared keys.
+func sameIndexKeys(existing bson.Raw, keys bson.D) bool {
+	elems, err := existing.Elements()
+	if err != nil || len(elems) != len(keys) {
+		return false
+	}
+	for i, elem := range elems {
+		if elem.Key() != keys[i].Key {
+			return false
+		}
+		value := elem.Value()
+		switch want := keys[i].Value.(type) {
+		case int32:
+			var got float64
+			switch value.Type {
+			case bson.TypeInt32:
+				got = float64(value.Int32())
+			case bson.TypeInt64:
+				got = float64(value.Int64())
+			case bson.TypeDouble:
+				got = value.Double()
+			default:
+				return false
+			}
+			if got != float64(want) {
+				return false
+			}
+		case string:
+			if str, ok := value.StringValueOK(); !ok || str != want {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// explainQueries returns the names of the indexes that each sample query's
+// winning plan uses.
+func (m *manageIndexes) explainQueries(ctx context.Context, db *mongo.Database) ([][]string, error) {
+	plans := make([][]string, 0, len(m.spec.Explain))
+	for i, query := range m.spec.Explain {
+		filter, err := parseExtJSONDocument(query.Filter)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing filter of query %d", i)
+		}
+		sort, err := parseExtJSONDocument(query.Sort)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing sort of query %d", i)
+		}
+		find := bson.D{{Key: "find", Value: m.collection}, {Key: "filter", Value: filter}}
+		if len(sort) > 0 {
+			find = append(find, bson.E{Key: "sort", Value: sort})
+		}
+
+		var res struct {
+			QueryPlanner bson.Raw `bson:"queryPlanner"`
+		}
+		if err := db.RunCommand(ctx, bson.D{
+			{Key: "explain", Value: find},
+			{Key: "verbosity", Value: "queryPlanner"},
+		}).Decode(&res); err != nil {
+			return nil, errors.Wrapf(err, "explaining query %d", i)
+		}
+		winningPlan, err := res.QueryPlanner.LookupErr("winningPlan")
+		if err != nil {
+			return nil, errors.Wrapf(err, "finding winning plan of query %d", i)
+		}
+		plans = append(plans, planIndexes(winningPlan))
+	}
+	return plans, nil
+}
+
+// planIndexes returns the names of the indexes used anywhere in the query
+// plan, or the collection scan stage if it uses none.
+func planIndexes(plan bson.RawValue) []string {
+	var names []string
+	var collScan bool
+	var walk func(value bson.RawValue)
+	walk = func(value bson.RawValue) {
+		var elems []bson.RawElement
+		switch value.Type {
+		case bson.TypeEmbeddedDocument:
+			elems, _ = value.Document().Elements()
+		case bson.TypeArray:
+			values, _ := value.Array().Values()
+			for _, v := range values {
+				walk(v)
+			}
+			return
+		default:
+			return
+		}
+		for _, elem := range elems {
+			if name, ok := elem.Value().StringValueOK(); ok && elem.Key() == explainIndexNameKey && !containsString(names, name) {
+				names = append(names, name)
+			}
+			if stage, ok := elem.Value().StringValueOK(); ok && elem.Key() == "stage" && stage == explainCollectionScanStage {
+				collScan = true
+			}
+			walk(elem.Value())
+		}
+	}
+	walk(plan)
+
+	if len(names) == 0 && collScan {
+		return []string{explainCollectionScanStage}
+	}
+	return names
+}
+
+func describePlanIndexes(indexes []string) string {
+	if len(indexes) == 0 {
+		return "no index"
+	}
+	if len(indexes) == 1 && indexes[0] == explainCollectionScanStage {
+		return "a collection scan"
+	}
+	return "index '" + strings.Join(indexes, "', '") + "'"
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var testIndexSpecFile = filepath.Join("testdata", manageIndexesName, "tasks", "spec.yaml")
+
+func TestLoadIndexSpec(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		spec, err := loadIndexSpec(testIndexSpecFile)
+		require.NoError(t, err)
+		assert.Equal(t, "tasks", spec.Collection)
+		require.Len(t, spec.Indexes, 4)
+		assert.Equal(t, indexKeys{{Key: "status", Value: int32(1)}, {Key: "create_time", Value: int32(-1)}}, spec.Indexes[0].Keys, "keys should keep their order")
+		assert.Equal(t, indexStatePresent, spec.Indexes[0].State, "state should default to present")
+		assert.Equal(t, indexStateHidden, spec.Indexes[2].State)
+		assert.Equal(t, indexStateAbsent, spec.Indexes[3].State)
+		require.Len(t, spec.Explain, 2)
+		assert.Equal(t, "status_1_create_time_-1", spec.Explain[0].ExpectIndex)
+	})
+
+	writeSpec := func(t *testing.T, contents string) string {
+		path := filepath.Join(t.TempDir(), "spec.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+		return path
+	}
+	t.Run("IndexTypes", func(t *testing.T) {
+		spec, err := loadIndexSpec(writeSpec(t, "indexes:\n  - name: text\n    keys: {description: text, \"1\": -1}\n"))
+		require.NoError(t, err)
+		assert.Equal(t, indexKeys{{Key: "description", Value: "text"}, {Key: "1", Value: int32(-1)}}, spec.Indexes[0].Keys)
+	})
+	for name, contents := range map[string]string{
+		"NoIndexes":            "collection: tasks\n",
+		"MissingKeys":          "indexes:\n  - name: a\n",
+		"InvalidState":         "indexes:\n  - name: a\n    keys: {a: 1}\n    state: gone\n",
+		"DuplicateName":        "indexes:\n  - name: a\n    keys: {a: 1}\n  - name: a\n    state: absent\n",
+		"IDIndex":              "indexes:\n  - name: _id_\n    state: absent\n",
+		"InvalidFilter":        "indexes:\n  - name: a\n    state: absent\nexplain:\n  - filter: 'not json'\n",
+		"KeysNotAMapping":      "indexes:\n  - name: a\n    keys: [a, b]\n",
+		"InvalidPartialFilter": "indexes:\n  - name: a\n    keys: {a: 1}\n    partial_filter: '{'\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := loadIndexSpec(writeSpec(t, contents))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSameIndexKeys(t *testing.T) {
+	existing, err := bson.Marshal(bson.D{{Key: "status", Value: 1.0}, {Key: "description", Value: "text"}})
+	require.NoError(t, err)
+
+	assert.True(t, sameIndexKeys(existing, bson.D{{Key: "status", Value: int32(1)}, {Key: "description", Value: "text"}}))
+	assert.False(t, sameIndexKeys(existing, bson.D{{Key: "description", Value: "text"}, {Key: "status", Value: int32(1)}}), "order should matter")
+	assert.False(t, sameIndexKeys(existing, bson.D{{Key: "status", Value: int32(-1)}, {Key: "description", Value: "text"}}))
+	assert.False(t, sameIndexKeys(existing, bson.D{{Key: "status", Value: int32(1)}}))
+}
+
+func TestCheckPartialFilterMatches(t *testing.T) {
+	existing, err := bson.Marshal(bson.D{{Key: "status", Value: "failed"}, {Key: "execution", Value: bson.D{{Key: "$gt", Value: 0.0}}}})
+	require.NoError(t, err)
+
+	equivalent, err := parseExtJSONDocument(`{"execution": {"$gt": 0}, "status": "failed"}`)
+	require.NoError(t, err)
+	assert.NoError(t, checkPartialFilterMatches(existing, equivalent), "field order and number types shouldn't matter")
+
+	different, err := parseExtJSONDocument(`{"execution": {"$gt": 1}, "status": "failed"}`)
+	require.NoError(t, err)
+	assert.Error(t, checkPartialFilterMatches(existing, different))
+	assert.Error(t, checkPartialFilterMatches(nil, equivalent))
+}
+
+func TestPlanIndexes(t *testing.T) {
+	plan := func(t *testing.T, extJSON string) bson.RawValue {
+		var doc bson.Raw
+		require.NoError(t, bson.UnmarshalExtJSON([]byte(extJSON), false, &doc))
+		return doc.Lookup("winningPlan")
+	}
+
+	assert.Equal(t, []string{"status_1"}, planIndexes(plan(t, `{"winningPlan": {"stage": "FETCH", "inputStage": {"stage": "IXSCAN", "indexName": "status_1"}}}`)))
+	assert.Equal(t, []string{"a_1", "b_1"}, planIndexes(plan(t, `{"winningPlan": {"queryPlan": {"stage": "OR", "inputStages": [{"stage": "IXSCAN", "indexName": "a_1"}, {"stage": "IXSCAN", "indexName": "b_1"}]}}}`)))
+	assert.Equal(t, []string{explainCollectionScanStage}, planIndexes(plan(t, `{"winningPlan": {"stage": "COLLSCAN"}}`)))
+}
+
+func TestManageIndexes(t *testing.T) {
+	t.Setenv(indexSpecFileEnvVar, testIndexSpecFile)
+
+	// Changing indexes leaves the documents as they are.
+	db := runGoldenTest(t, manageIndexesName, "tasks", MigrationOptions{})
+
+	// Applying the spec again changes nothing.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	migration, err := Registry.Migration(manageIndexesName, MigrationOptions{Database: db.Name()})
+	require.NoError(t, err)
+	require.NoError(t, migration.Execute(ctx, db.Client()))
+
+	coll := db.Collection("tasks")
+	for name, hidden := range map[string]bool{"status_1_create_time_-1": false, "branch_1_failed": false, "create_time_1": true} {
+		idx, err := findIndex(ctx, coll, name)
+		require.NoError(t, err)
+		require.NotNil(t, idx, "index '%s' should exist", name)
+		assert.Equal(t, hidden, idx.Hidden, "index '%s' should have hidden %t", name, hidden)
+	}
+	idx, err := findIndex(ctx, coll, "status_1")
+	require.NoError(t, err)
+	assert.Nil(t, idx, "index 'status_1' should be dropped")
+}
+
+func TestManageIndexesRejectsMismatchedIndex(t *testing.T) {
+	t.Setenv(indexSpecFileEnvVar, testIndexSpecFile)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(context.Background()))
+	}()
+	_, err := client.Database(db).Collection("tasks").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "create_time", Value: -1}},
+		Options: options.Index().SetName("create_time_1"),
+	})
+	require.NoError(t, err)
+
+	migration, err := Registry.Migration(manageIndexesName, MigrationOptions{Database: db})
+	require.NoError(t, err)
+	assert.Error(t, migration.Execute(ctx, client), "an existing index with different keys should not be changed")
+}
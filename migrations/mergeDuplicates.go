@@ -0,0 +1,417 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	mergeDuplicatesName       = "mergeDuplicates"
+	defaultDuplicateBatchSize = 100
+
+	duplicateStrategyKeepNewest  = "keepNewest"
+	duplicateStrategyKeepOldest  = "keepOldest"
+	duplicateStrategyMergeArrays = "mergeArrays"
+
+	// duplicateKeysEnvVar is a comma-separated list of the fields that
+	// identify a logical record. Documents with the same values for all of
+	// them are duplicates.
+	duplicateKeysEnvVar = "DUPLICATE_KEYS"
+	// duplicateStrategyEnvVar is how to resolve each group of duplicates:
+	// 'keepNewest' or 'keepOldest' keep the document with the latest or
+	// earliest timestamp and delete the others, and 'mergeArrays' adds the
+	// elements of the merge fields of every document in the group to the one
+	// with the earliest timestamp, or the lowest ID if there's no timestamp
+	// field, and deletes the others.
+	duplicateStrategyEnvVar = "DUPLICATE_STRATEGY"
+	// duplicateTimestampFieldEnvVar is the field that orders the documents
+	// in a group. It's required to keep the newest or oldest document. When
+	// merging arrays, the documents are ordered by ID if it's not set.
+	duplicateTimestampFieldEnvVar = "DUPLICATE_TIMESTAMP_FIELD"
+	// duplicateMergeFieldsEnvVar is a comma-separated list of the array
+	// fields to merge with the 'mergeArrays' strategy.
+	duplicateMergeFieldsEnvVar = "DUPLICATE_MERGE_FIELDS"
+	// duplicateFilterEnvVar is an extended JSON query for the documents to
+	// check for duplicates.
+	duplicateFilterEnvVar = "DUPLICATE_FILTER"
+	// duplicateDryRunEnvVar, if true, only reports the groups of duplicates.
+	duplicateDryRunEnvVar = "DUPLICATE_DRY_RUN"
+	// duplicateBackupDirEnvVar is the directory to back up every document in
+	// a group to before resolving it. Each run writes its backup to a new
+	// subdirectory, and restoring it with restoreCollection in upsert mode
+	// reverses the run. It's required unless it's a dry run.
+	duplicateBackupDirEnvVar = "DUPLICATE_BACKUP_DIR"
+
+	// duplicateHasTimestampKey is set on documents in the aggregation so the
+	// ones missing the timestamp are never preferred.
+	duplicateHasTimestampKey = "has_timestamp"
+)
+
+func init() {
+	Registry.registerMigration(mergeDuplicatesName, newMergeDuplicates, withRisk(RiskDestructive))
+}
+
+// mergeDuplicates finds groups of documents in a collection that have the
+// same values for a set of keys, and resolves each group to a single document.
+type mergeDuplicates struct {
+	database       string
+	collection     string
+	batchSize      int
+	keys           []string
+	strategy       string
+	timestampField string
+	mergeFields    []string
+	filter         bson.M
+	dryRun         bool
+	backupDir      string
+	clock          Clock
+}
+
+func newMergeDuplicates(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+	catcher.NewWhen(opts.Collection == "", "collection name not specified")
+
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultDuplicateBatchSize
+	}
+
+	m := &mergeDuplicates{
+		database:       opts.Database,
+		collection:     opts.Collection,
+		batchSize:      opts.BatchSize,
+		keys:           splitFieldList(os.Getenv(duplicateKeysEnvVar)),
+		strategy:       os.Getenv(duplicateStrategyEnvVar),
+		timestampField: os.Getenv(duplicateTimestampFieldEnvVar),
+		mergeFields:    splitFieldList(os.Getenv(duplicateMergeFieldsEnvVar)),
+		filter:         bson.M{},
+		backupDir:      os.Getenv(duplicateBackupDirEnvVar),
+		clock:          opts.getClock(),
+	}
+	catcher.ErrorfWhen(len(m.keys) == 0, "expected environment variable '%s' was not specified", duplicateKeysEnvVar)
+	for _, key := range m.keys {
+		catcher.ErrorfWhen(key == "_id", "'%s' can't include '_id', which is always unique", duplicateKeysEnvVar)
+	}
+
+	switch m.strategy {
+	case duplicateStrategyKeepNewest, duplicateStrategyKeepOldest:
+		catcher.ErrorfWhen(m.timestampField == "", "'%s' must be specified to use strategy '%s'", duplicateTimestampFieldEnvVar, m.strategy)
+		catcher.ErrorfWhen(len(m.mergeFields) > 0, "'%s' can only be used with strategy '%s'", duplicateMergeFieldsEnvVar, duplicateStrategyMergeArrays)
+	case duplicateStrategyMergeArrays:
+		catcher.ErrorfWhen(len(m.mergeFields) == 0, "'%s' must be specified to use strategy '%s'", duplicateMergeFieldsEnvVar, m.strategy)
+		for _, field := range m.mergeFields {
+			catcher.ErrorfWhen(overlapsKey(m.keys, field), "merge field '%s' can't be one of the keys", field)
+		}
+	default:
+		catcher.Errorf("'%s' must be '%s', '%s' or '%s'", duplicateStrategyEnvVar, duplicateStrategyKeepNewest, duplicateStrategyKeepOldest, duplicateStrategyMergeArrays)
+	}
+
+	if filterJSON := os.Getenv(duplicateFilterEnvVar); filterJSON != "" {
+		catcher.Wrapf(bson.UnmarshalExtJSON([]byte(filterJSON), false, &m.filter), "parsing '%s'", duplicateFilterEnvVar)
+	}
+	if dryRunStr := os.Getenv(duplicateDryRunEnvVar); dryRunStr != "" {
+		dryRun, err := strconv.ParseBool(dryRunStr)
+		catcher.Wrapf(err, "parsing '%s'", duplicateDryRunEnvVar)
+		m.dryRun = dryRun
+	}
+	catcher.ErrorfWhen(!m.dryRun && m.backupDir == "", "'%s' must be specified unless it's a dry run", duplicateBackupDirEnvVar)
+
+	return m, catcher.Resolve()
+}
+
+// splitFieldList splits a comma-separated list of fields, ignoring empty
+// entries.
+func splitFieldList(fieldsStr string) []string {
+	var fields []string
+	for _, field := range strings.Split(fieldsStr, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// overlapsKey returns whether the field is one of the keys or is within or
+// contains one of them.
+func overlapsKey(keys []string, field string) bool {
+	for _, key := range keys {
+		if field == key || strings.HasPrefix(field, key+".") || strings.HasPrefix(key, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicateGroup is a set of documents with the same keys. The IDs are in
+// order of preference, so the first document is the one that's kept.
+type duplicateGroup struct {
+	Key bson.Raw        `bson:"_id"`
+	IDs []bson.RawValue `bson:"ids"`
+}
+
+// ids returns the IDs of the documents in the group, starting at the given
+// index.
+func (g duplicateGroup) ids(start int) bson.A {
+	ids := make(bson.A, 0, len(g.IDs)-start)
+	for _, id := range g.IDs[start:] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Execute reports every group of duplicates and, unless it's a dry run,
+// resolves the groups in batches. Every document in a batch of groups is
+// backed up before any of them are changed, so the backup has the pre-image
+// of everything the script changed even if it's interrupted. Resolving a
+// group again leaves it unchanged, so rerunning the script finishes
+// resolving groups it was interrupted in the middle of.
+func (m *mergeDuplicates) Execute(ctx context.Context, client *mongo.Client) error {
+	coll := client.Database(m.database).Collection(m.collection)
+	cur, err := coll.Aggregate(ctx, m.duplicatesPipeline(), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return errors.Wrap(err, "finding duplicates")
+	}
+	defer cur.Close(ctx)
+
+	var backup *preImageBackup
+	var groups, duplicates, resolved int64
+	batch := make([]duplicateGroup, 0, m.batchSize)
+	resolveBatch := func() error {
+		defer func() { batch = batch[:0] }()
+		if m.dryRun || len(batch) == 0 {
+			return nil
+		}
+		if backup == nil {
+			var err error
+			backup, err = newPreImageBackup(m.backupDir, m.database, m.collection, m.clock)
+			if err != nil {
+				return errors.Wrap(err, "creating duplicate backup")
+			}
+		}
+		n, err := m.resolveBatch(ctx, coll, backup, batch)
+		resolved += n
+		return err
+	}
+
+	for cur.Next(ctx) {
+		var group duplicateGroup
+		if err := cur.Decode(&group); err != nil {
+			return errors.Wrap(err, "decoding group of duplicates")
+		}
+		groups++
+		duplicates += int64(len(group.IDs))
+		grip.Infof("Found %d documents in collection '%s' with %s", len(group.IDs), m.collection, m.describeKey(group))
+
+		batch = append(batch, group)
+		if len(batch) < m.batchSize {
+			continue
+		}
+		if err := resolveBatch(); err != nil {
+			return err
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return errors.Wrap(err, "iterating over duplicates")
+	}
+	if err := resolveBatch(); err != nil {
+		return err
+	}
+
+	if m.dryRun {
+		grip.Infof("Found %d groups of duplicates with %d documents in collection '%s'", groups, duplicates, m.collection)
+		return nil
+	}
+	if backup != nil {
+		grip.Infof("Backed up duplicates from collection '%s' to '%s'", m.collection, backup.dir)
+	}
+	grip.Infof("Resolved %d groups of duplicates with %d documents in collection '%s' using strategy '%s'", resolved, duplicates, m.collection, m.strategy)
+	return nil
+}
+
+// Preflight estimates the number of documents that will be changed or
+// deleted, which is none for a dry run.
+func (m *mergeDuplicates) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	reqs := &PreflightRequirements{Collections: []string{m.collection}}
+	if m.dryRun {
+		return reqs, nil
+	}
+
+	cur, err := client.Database(m.database).Collection(m.collection).Aggregate(ctx, m.duplicatesPipeline(), options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, errors.Wrap(err, "finding duplicates")
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var group duplicateGroup
+		if err := cur.Decode(&group); err != nil {
+			return nil, errors.Wrap(err, "decoding group of duplicates")
+		}
+		// Keeping a document leaves it unchanged, but merging arrays
+		// changes it.
+		reqs.EstimatedDocuments += int64(len(group.IDs) - 1)
+		if m.strategy == duplicateStrategyMergeArrays {
+			reqs.EstimatedDocuments++
+		}
+	}
+	return reqs, errors.Wrap(cur.Err(), "iterating over duplicates")
+}
+
+// duplicatesPipeline returns an aggregation that groups the documents
+// matching the filter by their keys and returns the groups with more than one
+// document. Each group's IDs are in order of preference: by timestamp, if
+// there is one, with documents missing the timestamp last, and then by ID.
+func (m *mergeDuplicates) duplicatesPipeline() bson.A {
+	match := bson.M{}
+	for _, key := range m.keys {
+		match[key] = bson.M{"$exists": true}
+	}
+	if len(m.filter) > 0 {
+		match = bson.M{"$and": bson.A{m.filter, match}}
+	}
+
+	sort := bson.D{}
+	if m.timestampField != "" {
+		direction := 1
+		if m.strategy == duplicateStrategyKeepNewest {
+			direction = -1
+		}
+		sort = append(sort,
+			bson.E{Key: duplicateHasTimestampKey, Value: -1},
+			bson.E{Key: m.timestampField, Value: direction},
+		)
+	}
+	sort = append(sort, bson.E{Key: "_id", Value: 1})
+
+	groupKey := bson.D{}
+	for i, key := range m.keys {
+		groupKey = append(groupKey, bson.E{Key: "k" + strconv.Itoa(i), Value: "$" + key})
+	}
+
+	pipeline := bson.A{bson.M{"$match": match}}
+	if m.timestampField != "" {
+		pipeline = append(pipeline, bson.M{"$addFields": bson.M{duplicateHasTimestampKey: bson.M{"$gt": bson.A{"$" + m.timestampField, nil}}}})
+	}
+	return append(pipeline,
+		bson.M{"$sort": sort},
+		bson.M{"$group": bson.M{
+			"_id": groupKey,
+			"ids": bson.M{"$push": "$_id"},
+		}},
+		bson.M{"$match": bson.M{"ids.1": bson.M{"$exists": true}}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	)
+}
+
+// describeKey returns the keys of the group and their values, for logging.
+// The aggregation names the keys by index, since they may be dotted paths.
+func (m *mergeDuplicates) describeKey(group duplicateGroup) string {
+	parts := make([]string, 0, len(m.keys))
+	for i, key := range m.keys {
+		parts = append(parts, key+"="+group.Key.Lookup("k"+strconv.Itoa(i)).String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// resolveBatch backs up every document in the groups, then resolves each
+// group. It returns the number of groups resolved.
+func (m *mergeDuplicates) resolveBatch(ctx context.Context, coll *mongo.Collection, backup *preImageBackup, groups []duplicateGroup) (int64, error) {
+	var ids bson.A
+	for _, group := range groups {
+		ids = append(ids, group.ids(0)...)
+	}
+	if _, err := backup.write(ctx, coll, ids); err != nil {
+		return 0, errors.Wrap(err, "backing up duplicates")
+	}
+
+	var resolved int64
+	var toDelete bson.A
+	for _, group := range groups {
+		if m.strategy == duplicateStrategyMergeArrays {
+			if err := m.mergeArrays(ctx, coll, group); err != nil {
+				return resolved, errors.Wrapf(err, "merging duplicates with %s", m.describeKey(group))
+			}
+		}
+		toDelete = append(toDelete, group.ids(1)...)
+		resolved++
+	}
+
+	if _, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": toDelete}}); err != nil {
+		return 0, errors.Wrap(err, "deleting duplicates")
+	}
+	return resolved, nil
+}
+
+// mergeArrays sets each merge field of the first document in the group to
+// the distinct elements of that field across the group, with the first
+// document's elements first.
+func (m *mergeDuplicates) mergeArrays(ctx context.Context, coll *mongo.Collection, group duplicateGroup) error {
+	projection := bson.M{}
+	for _, field := range m.mergeFields {
+		projection[field] = 1
+	}
+	cur, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": group.ids(0)}}, options.Find().SetProjection(projection))
+	if err != nil {
+		return errors.Wrap(err, "finding duplicates")
+	}
+	var docs []bson.Raw
+	if err := cur.All(ctx, &docs); err != nil {
+		return errors.Wrap(err, "iterating over duplicates")
+	}
+	byID := make(map[string]bson.Raw, len(docs))
+	for _, doc := range docs {
+		byID[doc.Lookup("_id").String()] = doc
+	}
+
+	update := bson.M{}
+	for _, field := range m.mergeFields {
+		merged := bson.A{}
+		seen := map[string]bool{}
+		found := false
+		for _, id := range group.IDs {
+			doc, ok := byID[id.String()]
+			if !ok {
+				continue
+			}
+			value, err := doc.LookupErr(strings.Split(field, ".")...)
+			if err != nil || value.Type == bson.TypeNull {
+				continue
+			}
+			found = true
+			elems, ok := value.ArrayOK()
+			if !ok {
+				return errors.Errorf("field '%s' of document %s is not an array", field, doc.Lookup("_id"))
+			}
+			values, err := elems.Values()
+			if err != nil {
+				return errors.Wrapf(err, "reading field '%s' of document %s", field, doc.Lookup("_id"))
+			}
+			for _, elem := range values {
+				// Elements are the same if they have the same type and
+				// encoding.
+				key := string(rune(elem.Type)) + string(elem.Value)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				merged = append(merged, elem)
+			}
+		}
+		if found {
+			update[field] = merged
+		}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+
+	_, err = coll.UpdateOne(ctx, bson.M{"_id": group.IDs[0]}, bson.M{"$set": update})
+	return errors.Wrap(err, "updating merged document")
+}
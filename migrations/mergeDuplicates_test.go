@@ -0,0 +1,130 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDuplicateKeys           = "task_id, task_execution"
+	testDuplicateTimestampField = "note.source.time"
+	testDuplicateMergeFields    = "issues, suspected_issues"
+)
+
+func TestNewMergeDuplicates(t *testing.T) {
+	opts := MigrationOptions{Database: "db", Collection: "task_annotations"}
+
+	t.Run("KeepNewest", func(t *testing.T) {
+		t.Setenv(duplicateKeysEnvVar, testDuplicateKeys)
+		t.Setenv(duplicateStrategyEnvVar, duplicateStrategyKeepNewest)
+		t.Setenv(duplicateTimestampFieldEnvVar, testDuplicateTimestampField)
+		t.Setenv(duplicateBackupDirEnvVar, t.TempDir())
+		migration, err := newMergeDuplicates(opts)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"task_id", "task_execution"}, migration.(*mergeDuplicates).keys)
+	})
+	t.Run("KeepNewestWithoutTimestamp", func(t *testing.T) {
+		t.Setenv(duplicateKeysEnvVar, testDuplicateKeys)
+		t.Setenv(duplicateStrategyEnvVar, duplicateStrategyKeepNewest)
+		t.Setenv(duplicateBackupDirEnvVar, t.TempDir())
+		_, err := newMergeDuplicates(opts)
+		assert.Error(t, err)
+	})
+	t.Run("MergeArraysWithoutFields", func(t *testing.T) {
+		t.Setenv(duplicateKeysEnvVar, testDuplicateKeys)
+		t.Setenv(duplicateStrategyEnvVar, duplicateStrategyMergeArrays)
+		t.Setenv(duplicateBackupDirEnvVar, t.TempDir())
+		_, err := newMergeDuplicates(opts)
+		assert.Error(t, err)
+	})
+	t.Run("MergeFieldIsKey", func(t *testing.T) {
+		t.Setenv(duplicateKeysEnvVar, testDuplicateKeys)
+		t.Setenv(duplicateStrategyEnvVar, duplicateStrategyMergeArrays)
+		t.Setenv(duplicateMergeFieldsEnvVar, "task_id")
+		t.Setenv(duplicateBackupDirEnvVar, t.TempDir())
+		_, err := newMergeDuplicates(opts)
+		assert.Error(t, err)
+	})
+	t.Run("MissingBackupDir", func(t *testing.T) {
+		t.Setenv(duplicateKeysEnvVar, testDuplicateKeys)
+		t.Setenv(duplicateStrategyEnvVar, duplicateStrategyKeepOldest)
+		t.Setenv(duplicateTimestampFieldEnvVar, testDuplicateTimestampField)
+		_, err := newMergeDuplicates(opts)
+		assert.Error(t, err)
+
+		t.Setenv(duplicateDryRunEnvVar, "true")
+		_, err = newMergeDuplicates(opts)
+		assert.NoError(t, err, "a dry run doesn't need a backup")
+	})
+	t.Run("InvalidStrategy", func(t *testing.T) {
+		t.Setenv(duplicateKeysEnvVar, testDuplicateKeys)
+		t.Setenv(duplicateStrategyEnvVar, "keepAll")
+		t.Setenv(duplicateBackupDirEnvVar, t.TempDir())
+		_, err := newMergeDuplicates(opts)
+		assert.Error(t, err)
+	})
+}
+
+func TestMergeDuplicates(t *testing.T) {
+	t.Setenv(duplicateKeysEnvVar, testDuplicateKeys)
+	opts := MigrationOptions{Collection: "task_annotations", BatchSize: 1}
+
+	t.Run("KeepNewest", func(t *testing.T) {
+		t.Setenv(duplicateStrategyEnvVar, duplicateStrategyKeepNewest)
+		t.Setenv(duplicateTimestampFieldEnvVar, testDuplicateTimestampField)
+		t.Setenv(duplicateBackupDirEnvVar, t.TempDir())
+		runGoldenTest(t, mergeDuplicatesName, "keepNewest", opts)
+	})
+	t.Run("MergeArrays", func(t *testing.T) {
+		t.Setenv(duplicateStrategyEnvVar, duplicateStrategyMergeArrays)
+		t.Setenv(duplicateMergeFieldsEnvVar, testDuplicateMergeFields)
+		t.Setenv(duplicateBackupDirEnvVar, t.TempDir())
+		runGoldenTest(t, mergeDuplicatesName, "mergeArrays", opts)
+	})
+	t.Run("Reversible", func(t *testing.T) {
+		backupDir := t.TempDir()
+		t.Setenv(duplicateStrategyEnvVar, duplicateStrategyMergeArrays)
+		t.Setenv(duplicateMergeFieldsEnvVar, testDuplicateMergeFields)
+		t.Setenv(duplicateBackupDirEnvVar, backupDir)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		client, db := newTestClient(ctx, t)
+		t.Cleanup(func() {
+			assert.NoError(t, client.Database(db).Drop(context.Background()))
+		})
+
+		_, err := testdata.LoadFixture(ctx, path.Join("testdata", mergeDuplicatesName, "mergeArrays"), db, client)
+		require.NoError(t, err)
+		before, err := testdata.SnapshotDatabase(ctx, client.Database(db))
+		require.NoError(t, err)
+
+		opts := opts
+		opts.Database = db
+		migration, err := Registry.Migration(mergeDuplicatesName, opts)
+		require.NoError(t, err)
+		require.NoError(t, migration.Execute(ctx, client))
+
+		entries, err := os.ReadDir(backupDir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "each run should write one backup")
+
+		// Restoring the pre-images reverses the merge.
+		t.Setenv(restoreDirEnvVar, filepath.Join(backupDir, entries[0].Name()))
+		t.Setenv(restoreModeEnvVar, restoreModeUpsert)
+		restore, err := Registry.Migration(restoreCollectionName, MigrationOptions{Database: db})
+		require.NoError(t, err)
+		require.NoError(t, restore.Execute(ctx, client))
+
+		after, err := testdata.SnapshotDatabase(ctx, client.Database(db))
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+}
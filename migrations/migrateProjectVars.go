@@ -0,0 +1,174 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/secretstore"
+	"github.com/evergreen-ci/evergreen/model"
+	"github.com/mongodb/anser/bsonutil"
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	migrateProjectVarsName = "migrateProjectVars"
+
+	// projectVarsSecretStoreEnvVar is the URL of the secret store to move
+	// project vars to. No production secret store is supported yet.
+	projectVarsSecretStoreEnvVar = "PROJECT_VARS_SECRET_STORE"
+)
+
+var projectVarsVarsKey = bsonutil.MustHaveTag(model.ProjectVars{}, "Vars")
+
+func init() {
+	Registry.registerMigration(migrateProjectVarsName, newMigrateProjectVars, withRisk(RiskDestructive), withIDSelection())
+}
+
+// migrateProjectVars moves project var values out of the database and into a
+// secret store.
+type migrateProjectVars struct {
+	database  string
+	store     secretstore.Store
+	startAtID string
+	limit     int
+	ids       *idSelection
+}
+
+func newMigrateProjectVars(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+
+	ids, err := newIDSelection()
+	catcher.Wrap(err, "invalid project ID selection")
+	m := &migrateProjectVars{
+		database:  opts.Database,
+		startAtID: os.Getenv(startAtProjectVarsAuthIDEnvVar),
+		ids:       ids,
+	}
+
+	storeURL := os.Getenv(projectVarsSecretStoreEnvVar)
+	if storeURL == "" {
+		catcher.Errorf("expected environment variable '%s' was not specified", projectVarsSecretStoreEnvVar)
+	} else {
+		store, err := secretstore.Open(storeURL)
+		catcher.Wrap(err, "opening secret store")
+		m.store = store
+	}
+	if limitStr := os.Getenv(projectVarsLimitEnvVar); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		catcher.Wrapf(err, "parsing '%s'", projectVarsLimitEnvVar)
+		catcher.ErrorfWhen(err == nil && limit < 0, "'%s' can't be negative", projectVarsLimitEnvVar)
+		m.limit = limit
+	}
+
+	return m, catcher.Resolve()
+}
+
+// Execute copies each project's vars to the secret store, with whether each
+// one is private or admin-only, and once they're all stored reads each one back
+// to verify it, so that a var overwritten by another with a colliding key is
+// caught. Only once all of a project's vars are verified does it unset them in
+// the database. The
+// private and admin-only flags are left in the database. Rerunning the script
+// puts the same values again for a project whose vars weren't unset.
+func (m *migrateProjectVars) Execute(ctx context.Context, client *mongo.Client) error {
+	coll := client.Database(m.database).Collection(model.ProjectVarsCollection)
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	if m.limit > 0 {
+		opts.SetLimit(int64(m.limit))
+	}
+	cur, err := coll.Find(ctx, m.query(), opts)
+	if err != nil {
+		return errors.Wrap(err, "finding project vars")
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		var vars model.ProjectVars
+		if err := cur.Decode(&vars); err != nil {
+			return errors.Wrap(err, "decoding project vars")
+		}
+		if err := m.migrateProject(ctx, coll, vars, cur.Current.Lookup(projectVarsVarsKey)); err != nil {
+			return errors.Wrapf(err, "migrating vars for project '%s'", vars.Id)
+		}
+	}
+	return errors.Wrap(cur.Err(), "iterating over project vars")
+}
+
+// Preflight estimates the number of projects whose vars will be moved.
+func (m *migrateProjectVars) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	count, err := client.Database(m.database).Collection(model.ProjectVarsCollection).CountDocuments(ctx, m.query())
+	if err != nil {
+		return nil, errors.Wrap(err, "counting project vars")
+	}
+	if m.limit > 0 && count > int64(m.limit) {
+		count = int64(m.limit)
+	}
+
+	return &PreflightRequirements{
+		Collections:        []string{model.ProjectVarsCollection},
+		EstimatedDocuments: count,
+	}, nil
+}
+
+func (m *migrateProjectVars) query() bson.M {
+	query := bson.M{projectVarsVarsKey: bson.M{"$exists": true}}
+	if m.startAtID != "" {
+		query["_id"] = bson.M{"$gte": m.startAtID}
+	}
+	m.ids.apply(query, "_id")
+	return query
+}
+
+// migrateProject puts the project's vars in the secret store and verifies
+// them, then unsets them in the database as long as they haven't changed since
+// they were read.
+func (m *migrateProjectVars) migrateProject(ctx context.Context, coll *mongo.Collection, vars model.ProjectVars, rawVars bson.RawValue) error {
+	names := make([]string, 0, len(vars.Vars))
+	for name := range vars.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	secrets := make(map[string]secretstore.Secret, len(names))
+	for _, name := range names {
+		secret := secretstore.Secret{
+			Value:     vars.Vars[name],
+			Private:   vars.PrivateVars[name],
+			AdminOnly: vars.AdminOnlyVars[name],
+		}
+		if err := m.store.Put(ctx, secretstore.Key{ProjectID: vars.Id, Name: name}, secret); err != nil {
+			return errors.Wrapf(err, "putting var '%s' in the secret store", name)
+		}
+		secrets[name] = secret
+	}
+	for _, name := range names {
+		stored, err := m.store.Get(ctx, secretstore.Key{ProjectID: vars.Id, Name: name})
+		if err != nil {
+			return errors.Wrapf(err, "reading back var '%s' from the secret store", name)
+		}
+		if stored != secrets[name] {
+			return errors.Errorf("var '%s' read back from the secret store doesn't match the value put in it", name)
+		}
+	}
+
+	res, err := coll.UpdateOne(ctx,
+		bson.M{"_id": vars.Id, projectVarsVarsKey: rawVars},
+		bson.M{"$unset": bson.M{projectVarsVarsKey: 1}},
+	)
+	if err != nil {
+		return errors.Wrap(err, "unsetting vars")
+	}
+	if res.MatchedCount == 0 {
+		return errors.New("vars changed while they were being moved; rerun the script to move the new values")
+	}
+
+	grip.Infof("Moved %d vars for project '%s' to the secret store", len(names), vars.Id)
+	return nil
+}
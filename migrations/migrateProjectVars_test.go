@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/secretstore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMigrateProjectVars(t *testing.T) {
+	opts := MigrationOptions{Database: "db"}
+
+	t.Run("MissingStore", func(t *testing.T) {
+		_, err := newMigrateProjectVars(opts)
+		assert.Error(t, err)
+	})
+	t.Run("UnsupportedStore", func(t *testing.T) {
+		t.Setenv(projectVarsSecretStoreEnvVar, "vault://secrets")
+		_, err := newMigrateProjectVars(opts)
+		assert.Error(t, err)
+	})
+	t.Run("InvalidLimit", func(t *testing.T) {
+		t.Setenv(projectVarsSecretStoreEnvVar, "file://"+t.TempDir())
+		t.Setenv(projectVarsLimitEnvVar, "-1")
+		_, err := newMigrateProjectVars(opts)
+		assert.Error(t, err)
+	})
+}
+
+func TestMigrateProjectVars(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(projectVarsSecretStoreEnvVar, "file://"+dir)
+	runGoldenTest(t, migrateProjectVarsName, "all", MigrationOptions{})
+
+	ctx := context.Background()
+	store := secretstore.NewFileStore(dir)
+	for key, expected := range map[secretstore.Key]secretstore.Secret{
+		{ProjectID: "project1", Name: "a"}: {Value: "1", AdminOnly: true},
+		{ProjectID: "project1", Name: "b"}: {Value: "2", Private: true},
+		{ProjectID: "project2", Name: "c"}: {Value: "3"},
+	} {
+		secret, err := store.Get(ctx, key)
+		require.NoError(t, err, key.String())
+		assert.Equal(t, expected, secret, key.String())
+	}
+}
@@ -0,0 +1,244 @@
+package migrations
+
+import (
+	"context"
+	"os"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gopkg.in/yaml.v3"
+)
+
+// Plan is an ordered sequence of migrations to run against a database.
+type Plan struct {
+	// Name identifies the plan in the run history, so steps that have
+	// already succeeded are skipped when the plan is applied again.
+	Name  string     `yaml:"name"`
+	Steps []PlanStep `yaml:"steps"`
+}
+
+// PlanStep is a migration to run as part of a plan.
+type PlanStep struct {
+	// Name identifies the step within the plan. It defaults to the script
+	// name.
+	Name       string `yaml:"name"`
+	Script     string `yaml:"script"`
+	Collection string `yaml:"collection"`
+	BatchSize  int    `yaml:"batch_size"`
+	// Params are set in the environment while the step runs.
+	Params map[string]string `yaml:"params"`
+	// DependsOn are the names of earlier steps that must succeed before this
+	// step runs.
+	DependsOn []string `yaml:"depends_on"`
+	// ContinueOnError continues with the rest of the plan if the step fails.
+	ContinueOnError bool `yaml:"continue_on_error"`
+	// MaxAffected is the most documents a destructive step may be estimated
+	// to affect. If the plan is also applied with a maximum, the smaller of
+	// the two is used.
+	MaxAffected int64 `yaml:"max_affected"`
+}
+
+// PlanOptions are the options for applying a plan.
+type PlanOptions struct {
+	Database string
+	// Confirm must be ConfirmToken for the plan and database if the plan has
+	// destructive steps.
+	Confirm string
+	// MaxAffectedDocuments is the most documents any destructive step may be
+	// estimated to affect. If a step sets its own maximum, the smaller of the
+	// two is used.
+	MaxAffectedDocuments int64
+	// IgnoreDependencies runs each step even if its script's prerequisites
+	// haven't succeeded against the database.
+	IgnoreDependencies bool
+	// Clock is the source of time for the steps and the run history. If it's
+	// not set, the system time is used.
+	Clock Clock
+}
+
+// LoadPlan reads a plan from a YAML file and validates it.
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading plan file '%s'", path)
+	}
+
+	plan := &Plan{}
+	if err := yaml.Unmarshal(data, plan); err != nil {
+		return nil, errors.Wrapf(err, "parsing plan file '%s'", path)
+	}
+	for i := range plan.Steps {
+		if plan.Steps[i].Name == "" {
+			plan.Steps[i].Name = plan.Steps[i].Script
+		}
+	}
+
+	return plan, errors.Wrap(plan.Validate(), "invalid plan")
+}
+
+// Validate checks that the plan's steps are registered scripts with unique
+// names and that steps only depend on earlier steps.
+func (p *Plan) Validate() error {
+	catcher := grip.NewBasicCatcher()
+	catcher.NewWhen(p.Name == "", "plan name not specified")
+	catcher.NewWhen(len(p.Steps) == 0, "plan has no steps")
+
+	seen := map[string]bool{}
+	for i, step := range p.Steps {
+		if step.Name == "" {
+			catcher.Errorf("step %d has no name", i)
+			continue
+		}
+		catcher.ErrorfWhen(seen[step.Name], "duplicate step name '%s'", step.Name)
+		for _, dep := range step.DependsOn {
+			catcher.ErrorfWhen(!seen[dep], "step '%s' depends on '%s', which is not an earlier step", step.Name, dep)
+		}
+		seen[step.Name] = true
+
+		info, err := Registry.Info(step.Script)
+		if err != nil {
+			catcher.Wrapf(err, "step '%s'", step.Name)
+			continue
+		}
+		catcher.ErrorfWhen(info.Version != 0, "step '%s' runs schema version %d, which must be applied with up", step.Name, info.Version)
+		catcher.ErrorfWhen(step.MaxAffected < 0, "step '%s' has a negative maximum number of affected documents", step.Name)
+	}
+
+	return catcher.Resolve()
+}
+
+// hasDestructiveSteps returns whether any of the plan's steps run a
+// destructive script.
+func (p *Plan) hasDestructiveSteps() bool {
+	for _, step := range p.Steps {
+		if info, err := Registry.Info(step.Script); err == nil && info.Risk == RiskDestructive {
+			return true
+		}
+	}
+	return false
+}
+
+// RunPlan runs the plan's steps in order. Steps that have already succeeded
+// for the plan are skipped, as are steps whose dependencies didn't succeed. If
+// a step fails, the plan is aborted unless the step continues on error, in
+// which case the failure is returned once the remaining steps have run.
+func RunPlan(ctx context.Context, client *mongo.Client, plan *Plan, opts PlanOptions) error {
+	if err := plan.Validate(); err != nil {
+		return errors.Wrap(err, "invalid plan")
+	}
+	if expected := ConfirmToken(plan.Name, opts.Database); plan.hasDestructiveSteps() && opts.Confirm != expected {
+		return errors.Errorf("plan '%s' has destructive steps and must be confirmed with '%s'", plan.Name, expected)
+	}
+
+	history := NewRunHistory(client.Database(opts.Database), opts.Clock)
+	succeeded := map[string]bool{}
+	catcher := grip.NewBasicCatcher()
+	for _, step := range plan.Steps {
+		done, err := history.StepSucceeded(ctx, plan.Name, step.Name)
+		if err != nil {
+			return errors.Wrapf(err, "checking run history for step '%s'", step.Name)
+		}
+		if done {
+			grip.Infof("Skipping step '%s': it has already succeeded", step.Name)
+			succeeded[step.Name] = true
+			continue
+		}
+
+		if dep := firstUnsucceeded(step.DependsOn, succeeded); dep != "" {
+			grip.Infof("Skipping step '%s': it depends on step '%s', which did not succeed", step.Name, dep)
+			catcher.Errorf("step '%s' was skipped because step '%s' did not succeed", step.Name, dep)
+			continue
+		}
+
+		grip.Infof("Running step '%s' with script '%s'", step.Name, step.Script)
+		if err := runPlanStep(ctx, client, plan, step, opts); err != nil {
+			err = errors.Wrapf(err, "running step '%s'", step.Name)
+			if !step.ContinueOnError {
+				catcher.Add(err)
+				return catcher.Resolve()
+			}
+			grip.Warningf("Continuing after step '%s' failed: %s", step.Name, err)
+			catcher.Add(err)
+			continue
+		}
+		succeeded[step.Name] = true
+	}
+
+	return catcher.Resolve()
+}
+
+func runPlanStep(ctx context.Context, client *mongo.Client, plan *Plan, step PlanStep, opts PlanOptions) error {
+	restore, err := setEnv(step.Params)
+	defer restore()
+	if err != nil {
+		return errors.Wrap(err, "setting step params")
+	}
+
+	return Run(ctx, client, RunOptions{
+		Name: step.Script,
+		Options: MigrationOptions{
+			Database:   opts.Database,
+			Collection: step.Collection,
+			BatchSize:  step.BatchSize,
+			Clock:      opts.Clock,
+		},
+		// Confirming the plan confirms each of its steps.
+		Safety: SafetyOptions{
+			Confirm:              ConfirmToken(step.Script, opts.Database),
+			MaxAffectedDocuments: stricterMaximum(step.MaxAffected, opts.MaxAffectedDocuments),
+		},
+		IgnoreDependencies: opts.IgnoreDependencies,
+		Plan:               plan.Name,
+		Step:               step.Name,
+	})
+}
+
+// stricterMaximum returns the smaller of two maximums, ignoring a maximum that
+// isn't set.
+func stricterMaximum(a, b int64) int64 {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 || a < b {
+		return a
+	}
+	return b
+}
+
+func firstUnsucceeded(names []string, succeeded map[string]bool) string {
+	for _, name := range names {
+		if !succeeded[name] {
+			return name
+		}
+	}
+	return ""
+}
+
+// setEnv sets the environment variables and returns a function that restores
+// their previous values.
+func setEnv(vars map[string]string) (func(), error) {
+	previous := map[string]*string{}
+	restore := func() {
+		for key, val := range previous {
+			if val == nil {
+				grip.Warning(os.Unsetenv(key))
+			} else {
+				grip.Warning(os.Setenv(key, *val))
+			}
+		}
+	}
+
+	for key, val := range vars {
+		if old, ok := os.LookupEnv(key); ok {
+			previous[key] = &old
+		} else {
+			previous[key] = nil
+		}
+		if err := os.Setenv(key, val); err != nil {
+			return restore, errors.Wrapf(err, "setting environment variable '%s'", key)
+		}
+	}
+
+	return restore, nil
+}
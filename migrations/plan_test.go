@@ -0,0 +1,150 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLoadPlan(t *testing.T) {
+	plan, err := LoadPlan("testdata/plan/release.yaml")
+	require.NoError(t, err)
+
+	assert.Equal(t, "release", plan.Name)
+	require.Len(t, plan.Steps, 3)
+	assert.Equal(t, deleteGitHubAppKeysName, plan.Steps[0].Name)
+	assert.Equal(t, map[string]string{githubAppAuthLimitEnvVar: "100"}, plan.Steps[0].Params)
+	assert.Equal(t, []string{deleteGitHubAppKeysName}, plan.Steps[1].DependsOn)
+	assert.True(t, plan.Steps[2].ContinueOnError)
+}
+
+func TestValidatePlan(t *testing.T) {
+	for name, test := range map[string]struct {
+		plan     Plan
+		expected string
+	}{
+		"UnregisteredScript": {
+			plan:     Plan{Name: "p", Steps: []PlanStep{{Name: "a", Script: "nope"}}},
+			expected: "no migration exists for name 'nope'",
+		},
+		"DuplicateStep": {
+			plan:     Plan{Name: "p", Steps: []PlanStep{{Name: "a", Script: helloWorld}, {Name: "a", Script: helloWorld}}},
+			expected: "duplicate step name 'a'",
+		},
+		"DependsOnLaterStep": {
+			plan:     Plan{Name: "p", Steps: []PlanStep{{Name: "a", Script: helloWorld, DependsOn: []string{"b"}}, {Name: "b", Script: helloWorld}}},
+			expected: "step 'a' depends on 'b', which is not an earlier step",
+		},
+		"NegativeMaximum": {
+			plan:     Plan{Name: "p", Steps: []PlanStep{{Name: "a", Script: deleteProjectVarsName, MaxAffected: -1}}},
+			expected: "step 'a' has a negative maximum number of affected documents",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := test.plan.Validate()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expected)
+		})
+	}
+}
+
+func TestRunPlan(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(ctx))
+	}()
+	_, err := client.Database(db).Collection("hello").InsertOne(ctx, bson.M{"_id": "world"})
+	require.NoError(t, err)
+
+	plan := &Plan{
+		Name: "plan",
+		Steps: []PlanStep{
+			{Name: "first", Script: helloWorld, Collection: "hello"},
+			{Name: "fails", Script: helloWorld, Collection: "missing", ContinueOnError: true},
+			{Name: "dependent", Script: helloWorld, Collection: "hello", DependsOn: []string{"fails"}},
+			{Name: "last", Script: helloWorld, Collection: "hello", Params: map[string]string{"PLAN_TEST_PARAM": "set"}},
+		},
+	}
+
+	stepStatuses := func() map[string][]RunStatus {
+		cur, err := client.Database(db).Collection(RunHistoryCollection).Find(ctx, bson.M{"plan": plan.Name})
+		require.NoError(t, err)
+		var records []RunRecord
+		require.NoError(t, cur.All(ctx, &records))
+		statuses := map[string][]RunStatus{}
+		for _, record := range records {
+			statuses[record.Step] = append(statuses[record.Step], record.Status)
+		}
+		return statuses
+	}
+
+	// The failing step's collection doesn't exist, so it fails its preflight
+	// checks before a run is recorded.
+	err = RunPlan(ctx, client, plan, PlanOptions{Database: db})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "collection 'missing' does not exist")
+	assert.Contains(t, err.Error(), "step 'dependent' was skipped")
+	assert.Equal(t, map[string][]RunStatus{
+		"first": {RunStatusSucceeded},
+		"last":  {RunStatusSucceeded},
+	}, stepStatuses())
+	_, ok := os.LookupEnv("PLAN_TEST_PARAM")
+	assert.False(t, ok, "step params should be restored after the step")
+
+	t.Run("ReapplyingSkipsSucceededSteps", func(t *testing.T) {
+		_, err := client.Database(db).Collection("missing").InsertOne(ctx, bson.M{"_id": "now exists"})
+		require.NoError(t, err)
+
+		require.NoError(t, RunPlan(ctx, client, plan, PlanOptions{Database: db}))
+		assert.Equal(t, map[string][]RunStatus{
+			"first":     {RunStatusSucceeded},
+			"fails":     {RunStatusSucceeded},
+			"dependent": {RunStatusSucceeded},
+			"last":      {RunStatusSucceeded},
+		}, stepStatuses())
+	})
+	t.Run("DestructivePlanRequiresConfirmation", func(t *testing.T) {
+		destructive := &Plan{Name: "destructive", Steps: []PlanStep{{Name: "a", Script: deleteProjectVarsName, MaxAffected: 1}}}
+		err := RunPlan(ctx, client, destructive, PlanOptions{Database: db})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must be confirmed with 'destructive@"+db+"'")
+	})
+	t.Run("GlobalMaximum", func(t *testing.T) {
+		_, err := client.Database(db).Collection(model.ProjectVarsCollection).InsertMany(ctx, []interface{}{
+			bson.M{"_id": "project1", "vars": bson.M{"a": "1"}},
+			bson.M{"_id": "project2", "vars": bson.M{"b": "2"}},
+		})
+		require.NoError(t, err)
+		destructive := &Plan{Name: "destructive", Steps: []PlanStep{{Name: "a", Script: deleteProjectVarsName}}}
+		confirm := ConfirmToken(destructive.Name, db)
+
+		err = RunPlan(ctx, client, destructive, PlanOptions{Database: db, Confirm: confirm})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "requires a maximum number of affected documents")
+
+		err = RunPlan(ctx, client, destructive, PlanOptions{Database: db, Confirm: confirm, MaxAffectedDocuments: 1})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "estimated to affect 2 documents, which exceeds the maximum of 1")
+
+		require.NoError(t, RunPlan(ctx, client, destructive, PlanOptions{Database: db, Confirm: confirm, MaxAffectedDocuments: 2}))
+		count, err := client.Database(db).Collection(model.ProjectVarsCollection).CountDocuments(ctx, bson.M{"vars": bson.M{"$exists": true}})
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+}
+
+func TestStricterMaximum(t *testing.T) {
+	assert.EqualValues(t, 0, stricterMaximum(0, 0))
+	assert.EqualValues(t, 5, stricterMaximum(5, 0))
+	assert.EqualValues(t, 5, stricterMaximum(0, 5))
+	assert.EqualValues(t, 3, stricterMaximum(3, 5))
+	assert.EqualValues(t, 3, stricterMaximum(5, 3))
+}
@@ -0,0 +1,181 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Preflighter is implemented by migrations that can describe what they need
+// from the database and estimate how much they'll change before they run.
+type Preflighter interface {
+	// Preflight returns the migration's requirements. It must not modify
+	// the database.
+	Preflight(context.Context, *mongo.Client) (*PreflightRequirements, error)
+}
+
+// PreflightRequirements describe what a migration needs from the database and
+// how much it's expected to change.
+type PreflightRequirements struct {
+	// Collections are the collections that must exist.
+	Collections []string
+	// Indexes are the indexes that the migration's queries rely on.
+	Indexes []RequiredIndex
+	// EstimatedDocuments is the number of documents the migration is expected
+	// to modify or delete.
+	EstimatedDocuments int64
+}
+
+// RequiredIndex is an index that a migration's queries rely on.
+type RequiredIndex struct {
+	Collection string
+	// Fields must be the leading fields of the index, in any order.
+	Fields []string
+}
+
+// Topology is the deployment type of a MongoDB server.
+type Topology string
+
+const (
+	TopologyStandalone Topology = "standalone"
+	TopologyReplicaSet Topology = "replica set"
+	TopologySharded    Topology = "sharded"
+)
+
+// PreflightReport is the result of checking that the database is ready for a
+// migration.
+type PreflightReport struct {
+	ServerVersion string
+	Topology      Topology
+	// Requirements are the migration's requirements. They're nil if the
+	// migration doesn't implement Preflighter.
+	Requirements *PreflightRequirements
+}
+
+// RunPreflight checks that the database is ready for the migration to run: the
+// server is reachable with the client's credentials, the target database and
+// collection exist unless the migration creates them, and the collections and
+// indexes the migration requires exist. It returns what it found, along with an
+// error describing every check that failed.
+func RunPreflight(ctx context.Context, client *mongo.Client, info MigrationInfo, opts MigrationOptions, migration Migration) (*PreflightReport, error) {
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return nil, errors.Wrap(err, "connecting to the database")
+	}
+
+	report := &PreflightReport{}
+	var err error
+	report.ServerVersion, err = getServerVersion(ctx, client)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting server version")
+	}
+	report.Topology, err = getTopology(ctx, client)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting server topology")
+	}
+
+	db := client.Database(opts.Database)
+	existingCollections, err := db.ListCollectionNames(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing collections in database '%s'", opts.Database)
+	}
+	collectionExists := make(map[string]bool, len(existingCollections))
+	for _, name := range existingCollections {
+		collectionExists[name] = true
+	}
+
+	catcher := grip.NewBasicCatcher()
+	if !info.CreatesTarget {
+		catcher.ErrorfWhen(len(existingCollections) == 0, "database '%s' does not exist", opts.Database)
+		catcher.ErrorfWhen(opts.Collection != "" && !collectionExists[opts.Collection], "collection '%s' does not exist", opts.Collection)
+	}
+
+	preflighter, ok := migration.(Preflighter)
+	if !ok {
+		return report, catcher.Resolve()
+	}
+	report.Requirements, err = preflighter.Preflight(ctx, client)
+	if err != nil {
+		catcher.Wrap(err, "getting migration requirements")
+		return report, catcher.Resolve()
+	}
+
+	for _, collection := range report.Requirements.Collections {
+		catcher.ErrorfWhen(!collectionExists[collection], "required collection '%s' does not exist", collection)
+	}
+	for _, idx := range report.Requirements.Indexes {
+		exists, err := indexExists(ctx, db.Collection(idx.Collection), idx.Fields)
+		if err != nil {
+			catcher.Wrapf(err, "checking for index on %v in collection '%s'", idx.Fields, idx.Collection)
+			continue
+		}
+		catcher.ErrorfWhen(!exists, "collection '%s' has no index on %v", idx.Collection, idx.Fields)
+	}
+
+	return report, catcher.Resolve()
+}
+
+func getServerVersion(ctx context.Context, client *mongo.Client) (string, error) {
+	var buildInfo struct {
+		Version string `bson:"version"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.M{"buildInfo": 1}).Decode(&buildInfo); err != nil {
+		return "", err
+	}
+	return buildInfo.Version, nil
+}
+
+func getTopology(ctx context.Context, client *mongo.Client) (Topology, error) {
+	var hello struct {
+		Msg     string `bson:"msg"`
+		SetName string `bson:"setName"`
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.M{"hello": 1}).Decode(&hello); err != nil {
+		return "", err
+	}
+
+	switch {
+	case hello.Msg == "isdbgrid":
+		return TopologySharded, nil
+	case hello.SetName != "":
+		return TopologyReplicaSet, nil
+	default:
+		return TopologyStandalone, nil
+	}
+}
+
+// indexExists returns whether the collection has an index whose leading
+// fields are the given fields, in any order.
+func indexExists(ctx context.Context, coll *mongo.Collection, fields []string) (bool, error) {
+	specs, err := coll.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, spec := range specs {
+		var keys bson.D
+		if err := bson.Unmarshal(spec.KeysDocument, &keys); err != nil {
+			return false, errors.Wrapf(err, "decoding keys for index '%s'", spec.Name)
+		}
+		if len(keys) < len(fields) {
+			continue
+		}
+
+		leading := make(map[string]bool, len(fields))
+		for _, key := range keys[:len(fields)] {
+			leading[key.Key] = true
+		}
+		matches := true
+		for _, field := range fields {
+			matches = matches && leading[field]
+		}
+		if matches {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
+	"github.com/evergreen-ci/evergreen-migrations/migrations/testdata"
+	"github.com/evergreen-ci/evergreen/model/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestRunPreflight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	fixture, err := testdata.LoadFixture(ctx, path.Join("testdata", redactProjectEventSecretsName, "all"), db, client)
+	defer func() {
+		require.NoError(t, fixture.Cleanup(ctx))
+	}()
+	require.NoError(t, err)
+
+	runPreflight := func(t *testing.T, name string, opts MigrationOptions) (*PreflightReport, error) {
+		info, err := Registry.Info(name)
+		require.NoError(t, err)
+		migration, err := Registry.Migration(name, opts)
+		require.NoError(t, err)
+		return RunPreflight(ctx, client, info, opts, migration)
+	}
+	opts := MigrationOptions{Database: db}
+
+	t.Run("MissingIndex", func(t *testing.T) {
+		report, err := runPreflight(t, redactProjectEventSecretsName, opts)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "collection 'events' has no index")
+		require.NotNil(t, report)
+		assert.NotEmpty(t, report.ServerVersion)
+		assert.Equal(t, TopologyReplicaSet, report.Topology)
+		require.NotNil(t, report.Requirements)
+		assert.EqualValues(t, 3, report.Requirements.EstimatedDocuments)
+	})
+	t.Run("MissingRequiredCollection", func(t *testing.T) {
+		_, err := runPreflight(t, deleteProjectVarsName, opts)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "required collection 'project_vars' does not exist")
+	})
+	t.Run("MissingTargetCollection", func(t *testing.T) {
+		// The target is checked even for scripts that don't declare their
+		// requirements.
+		_, err := runPreflight(t, helloWorld, MigrationOptions{Database: db, Collection: "nonexistent"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "collection 'nonexistent' does not exist")
+	})
+	t.Run("MissingTargetDatabase", func(t *testing.T) {
+		_, err := runPreflight(t, helloWorld, MigrationOptions{Database: db + "_nonexistent", Collection: "events"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "database '"+db+"_nonexistent' does not exist")
+	})
+	t.Run("TargetCreatedByScript", func(t *testing.T) {
+		t.Setenv(restoreDirEnvVar, writeTestArchive(t, archive.FormatJSONL))
+		_, err := runPreflight(t, restoreCollectionName, MigrationOptions{Database: db + "_new", Collection: "nonexistent"})
+		assert.NoError(t, err, "a script that creates its target shouldn't need it to exist")
+	})
+	t.Run("IndexInDifferentOrder", func(t *testing.T) {
+		_, err := client.Database(db).Collection(event.EventCollection).Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: eventTypeKey, Value: 1}, {Key: event.ResourceTypeKey, Value: 1}, {Key: event.ResourceIdKey, Value: 1}, {Key: event.TimestampKey, Value: 1}},
+		})
+		require.NoError(t, err)
+
+		_, err = runPreflight(t, redactProjectEventSecretsName, opts)
+		assert.NoError(t, err)
+	})
+}
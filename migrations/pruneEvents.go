@@ -0,0 +1,342 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evergreen-ci/evergreen/model/event"
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	pruneEventsName           = "pruneEvents"
+	defaultPruneBatchSize     = 1000
+	eventRetentionAnyType     = "*"
+	eventRetentionTypeSep     = "/"
+	eventRetentionPeriodSep   = "="
+	eventRetentionRuleSep     = ","
+	eventResourceTypeTallyKey = "r_type"
+	eventTypeTallyKey         = "e_type"
+
+	// eventRetentionEnvVar is a comma-separated list of retention rules, each
+	// written as <r_type>=<duration> or <r_type>/<e_type>=<duration>, where
+	// the duration is how long to keep the events, e.g.
+	// 'TASK=720h,HOST/HOST_CREATED=24h,*=8760h'. A rule for an event type
+	// takes precedence over a rule for its resource type, and the '*' rule
+	// applies to events that no other rule matches. Events that no rule
+	// matches are kept.
+	eventRetentionEnvVar = "EVENT_RETENTION"
+	// eventPruneArchiveDirEnvVar, if set, is the directory to archive events
+	// to before deleting them. Each run writes its archive to a new
+	// subdirectory, and restoreCollection can restore it.
+	eventPruneArchiveDirEnvVar = "EVENT_PRUNE_ARCHIVE_DIR"
+	// eventPruneDryRunEnvVar, if true, only counts the events that would be
+	// deleted.
+	eventPruneDryRunEnvVar = "EVENT_PRUNE_DRY_RUN"
+)
+
+func init() {
+	Registry.registerMigration(pruneEventsName, newPruneEvents, withRisk(RiskDestructive))
+}
+
+// eventRetentionRule is how long to keep the events of a resource type, or of
+// an event type of a resource type.
+type eventRetentionRule struct {
+	// resourceType is '*' for the rule that applies to all other events.
+	resourceType string
+	// eventType is empty for a rule that applies to every event type of the
+	// resource type.
+	eventType string
+	retention time.Duration
+}
+
+func (r eventRetentionRule) String() string {
+	if r.eventType == "" {
+		return r.resourceType
+	}
+	return r.resourceType + eventRetentionTypeSep + r.eventType
+}
+
+// match returns the query for the events the rule covers, ignoring other
+// rules.
+func (r eventRetentionRule) match() bson.M {
+	if r.resourceType == eventRetentionAnyType {
+		return bson.M{}
+	}
+	match := bson.M{event.ResourceTypeKey: r.resourceType}
+	if r.eventType != "" {
+		match[event.TypeKey] = r.eventType
+	}
+	return match
+}
+
+// specificity orders rules so that more specific rules take precedence.
+func (r eventRetentionRule) specificity() int {
+	switch {
+	case r.resourceType == eventRetentionAnyType:
+		return 0
+	case r.eventType == "":
+		return 1
+	default:
+		return 2
+	}
+}
+
+// parseEventRetentionRules parses a comma-separated list of retention rules.
+func parseEventRetentionRules(rulesStr string) ([]eventRetentionRule, error) {
+	catcher := grip.NewBasicCatcher()
+	var rules []eventRetentionRule
+	seen := map[string]bool{}
+	for _, ruleStr := range strings.Split(rulesStr, eventRetentionRuleSep) {
+		ruleStr = strings.TrimSpace(ruleStr)
+		if ruleStr == "" {
+			continue
+		}
+		types, retentionStr, ok := strings.Cut(ruleStr, eventRetentionPeriodSep)
+		if !ok {
+			catcher.Errorf("rule '%s' must be written as <r_type>[/<e_type>]=<duration>", ruleStr)
+			continue
+		}
+		resourceType, eventType, _ := strings.Cut(strings.TrimSpace(types), eventRetentionTypeSep)
+		rule := eventRetentionRule{
+			resourceType: strings.TrimSpace(resourceType),
+			eventType:    strings.TrimSpace(eventType),
+		}
+		retention, err := time.ParseDuration(strings.TrimSpace(retentionStr))
+		catcher.Wrapf(err, "parsing retention of rule '%s'", ruleStr)
+		catcher.ErrorfWhen(err == nil && retention <= 0, "retention of rule '%s' must be positive", ruleStr)
+		rule.retention = retention
+
+		catcher.ErrorfWhen(rule.resourceType == "", "rule '%s' has no resource type", ruleStr)
+		catcher.ErrorfWhen(rule.resourceType == eventRetentionAnyType && rule.eventType != "", "rule '%s' can't have an event type for every resource type", ruleStr)
+		catcher.ErrorfWhen(seen[rule.String()], "there's more than one rule for '%s'", rule)
+		seen[rule.String()] = true
+		rules = append(rules, rule)
+	}
+	catcher.NewWhen(len(rules) == 0 && !catcher.HasErrors(), "no retention rules specified")
+
+	// Prune with the most specific rules first, so the report lists them
+	// first.
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].specificity() > rules[j].specificity()
+	})
+	return rules, catcher.Resolve()
+}
+
+// pruneEvents deletes events from the event log that are older than their
+// resource or event type's retention, optionally archiving them first.
+type pruneEvents struct {
+	database   string
+	batchSize  int
+	rules      []eventRetentionRule
+	archiveDir string
+	dryRun     bool
+	clock      Clock
+}
+
+func newPruneEvents(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+	catcher.ErrorfWhen(opts.Collection != "" && opts.Collection != event.EventCollection, "collection must be '%s'", event.EventCollection)
+
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultPruneBatchSize
+	}
+
+	p := &pruneEvents{
+		database:   opts.Database,
+		batchSize:  opts.BatchSize,
+		archiveDir: os.Getenv(eventPruneArchiveDirEnvVar),
+		clock:      opts.getClock(),
+	}
+	rulesStr := os.Getenv(eventRetentionEnvVar)
+	if rulesStr == "" {
+		catcher.Errorf("expected environment variable '%s' was not specified", eventRetentionEnvVar)
+	} else {
+		rules, err := parseEventRetentionRules(rulesStr)
+		catcher.Wrapf(err, "parsing '%s'", eventRetentionEnvVar)
+		p.rules = rules
+	}
+	if dryRunStr := os.Getenv(eventPruneDryRunEnvVar); dryRunStr != "" {
+		dryRun, err := strconv.ParseBool(dryRunStr)
+		catcher.Wrapf(err, "parsing '%s'", eventPruneDryRunEnvVar)
+		p.dryRun = dryRun
+	}
+
+	return p, catcher.Resolve()
+}
+
+// ruleQuery returns the query for the expired events that the rule applies
+// to, which excludes the events that a more specific rule applies to.
+func (p *pruneEvents) ruleQuery(rule eventRetentionRule, now time.Time) bson.M {
+	var overridden bson.A
+	for _, other := range p.rules {
+		if other.specificity() > rule.specificity() && (rule.resourceType == eventRetentionAnyType || other.resourceType == rule.resourceType) {
+			overridden = append(overridden, other.match())
+		}
+	}
+
+	query := rule.match()
+	query[event.TimestampKey] = bson.M{"$lt": now.Add(-rule.retention)}
+	if len(overridden) > 0 {
+		query["$nor"] = overridden
+	}
+	return query
+}
+
+// eventTypeCount is the number of events of a resource and event type.
+type eventTypeCount struct {
+	ResourceType string `bson:"r_type"`
+	EventType    string `bson:"e_type"`
+	Count        int64  `bson:"count"`
+}
+
+// Execute deletes the expired events for each rule in batches ordered by ID,
+// archiving each batch first if there's an archive directory. It reports the
+// number of events deleted for each resource and event type. Rerunning the
+// script deletes the events that have expired since.
+func (p *pruneEvents) Execute(ctx context.Context, client *mongo.Client) error {
+	coll := client.Database(p.database).Collection(event.EventCollection)
+	now := p.clock.Now()
+	if p.dryRun {
+		counts, err := p.countExpired(ctx, coll, now)
+		if err != nil {
+			return err
+		}
+		p.report("Would delete", counts)
+		return nil
+	}
+
+	var eventArchive *preImageBackup
+	deleted := map[string]*eventTypeCount{}
+	for _, rule := range p.rules {
+		query := p.ruleQuery(rule, now)
+		err := forEachIDBatch(ctx, coll, query, p.batchSize, func(ids bson.A) error {
+			if p.archiveDir != "" {
+				if eventArchive == nil {
+					var err error
+					eventArchive, err = newPreImageBackup(p.archiveDir, p.database, event.EventCollection, p.clock)
+					if err != nil {
+						return errors.Wrap(err, "creating event archive")
+					}
+				}
+				archived, err := eventArchive.write(ctx, coll, ids)
+				if err != nil {
+					return errors.Wrap(err, "archiving events")
+				}
+				ids = archived
+			}
+
+			counts, err := countEventTypes(ctx, coll, bson.M{"_id": bson.M{"$in": ids}})
+			if err != nil {
+				return errors.Wrap(err, "counting events to delete")
+			}
+			if _, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+				return errors.Wrap(err, "deleting batch of events")
+			}
+			for _, count := range counts {
+				addEventTypeCount(deleted, count)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "pruning events for rule '%s'", rule)
+		}
+	}
+
+	if eventArchive != nil {
+		grip.Infof("Archived deleted events to '%s'", eventArchive.dir)
+	}
+	p.report("Deleted", deleted)
+	return nil
+}
+
+// Preflight counts the events that will be deleted, which is none for a dry
+// run.
+func (p *pruneEvents) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	reqs := &PreflightRequirements{Collections: []string{event.EventCollection}}
+	if p.dryRun {
+		return reqs, nil
+	}
+
+	counts, err := p.countExpired(ctx, client.Database(p.database).Collection(event.EventCollection), p.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+	for _, count := range counts {
+		reqs.EstimatedDocuments += count.Count
+	}
+	return reqs, nil
+}
+
+// countExpired counts the expired events for each resource and event type.
+func (p *pruneEvents) countExpired(ctx context.Context, coll *mongo.Collection, now time.Time) (map[string]*eventTypeCount, error) {
+	expired := map[string]*eventTypeCount{}
+	for _, rule := range p.rules {
+		counts, err := countEventTypes(ctx, coll, p.ruleQuery(rule, now))
+		if err != nil {
+			return nil, errors.Wrapf(err, "counting expired events for rule '%s'", rule)
+		}
+		for _, count := range counts {
+			addEventTypeCount(expired, count)
+		}
+	}
+	return expired, nil
+}
+
+// countEventTypes counts the events matching the query for each resource and
+// event type.
+func countEventTypes(ctx context.Context, coll *mongo.Collection, query bson.M) ([]eventTypeCount, error) {
+	cur, err := coll.Aggregate(ctx, bson.A{
+		bson.M{"$match": query},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{eventResourceTypeTallyKey: "$" + event.ResourceTypeKey, eventTypeTallyKey: "$" + event.TypeKey},
+			"count": bson.M{"$sum": 1},
+		}},
+		bson.M{"$project": bson.M{
+			"_id":                     0,
+			eventResourceTypeTallyKey: "$_id." + eventResourceTypeTallyKey,
+			eventTypeTallyKey:         "$_id." + eventTypeTallyKey,
+			"count":                   1,
+		}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	var counts []eventTypeCount
+	return counts, cur.All(ctx, &counts)
+}
+
+func addEventTypeCount(counts map[string]*eventTypeCount, count eventTypeCount) {
+	key := count.ResourceType + eventRetentionTypeSep + count.EventType
+	if existing, ok := counts[key]; ok {
+		existing.Count += count.Count
+		return
+	}
+	counts[key] = &count
+}
+
+// report logs the counts for each resource and event type, and the total.
+func (p *pruneEvents) report(verb string, counts map[string]*eventTypeCount) {
+	keys := make([]string, 0, len(counts))
+	var total int64
+	for key, count := range counts {
+		keys = append(keys, key)
+		total += count.Count
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		count := counts[key]
+		grip.Infof("%s %d events with resource type '%s' and event type '%s'", verb, count.Count, count.ResourceType, count.EventType)
+	}
+	grip.Infof("%s %d events in total from collection '%s'", verb, total, event.EventCollection)
+}
@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
+	"github.com/evergreen-ci/evergreen-migrations/migrations/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const testEventRetention = "*=4320h, TASK=720h, HOST/HOST_CREATED=24h"
+
+var testEventPruneNow = time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+func TestParseEventRetentionRules(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		rules, err := parseEventRetentionRules(testEventRetention)
+		require.NoError(t, err)
+		assert.Equal(t, []eventRetentionRule{
+			{resourceType: "HOST", eventType: "HOST_CREATED", retention: 24 * time.Hour},
+			{resourceType: "TASK", retention: 720 * time.Hour},
+			{resourceType: "*", retention: 4320 * time.Hour},
+		}, rules, "rules should be ordered from most to least specific")
+	})
+	for name, rulesStr := range map[string]string{
+		"MissingRetention":  "TASK",
+		"InvalidRetention":  "TASK=30 days",
+		"NegativeRetention": "TASK=-1h",
+		"Duplicate":         "TASK=1h,TASK=2h",
+		"AnyWithEventType":  "*/TASK_FINISHED=1h",
+		"MissingType":       "=1h",
+		"Empty":             " , ",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseEventRetentionRules(rulesStr)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPruneEvents(t *testing.T) {
+	t.Setenv(eventRetentionEnvVar, testEventRetention)
+	opts := MigrationOptions{BatchSize: 2, Clock: testdata.NewFakeClock(testEventPruneNow)}
+
+	t.Run("Delete", func(t *testing.T) {
+		runGoldenTest(t, pruneEventsName, "retention", opts)
+	})
+	t.Run("Archive", func(t *testing.T) {
+		archiveDir := t.TempDir()
+		t.Setenv(eventPruneArchiveDirEnvVar, archiveDir)
+		runGoldenTest(t, pruneEventsName, "retention", opts)
+
+		entries, err := os.ReadDir(archiveDir)
+		require.NoError(t, err)
+		require.Len(t, entries, 1, "each run should write one archive")
+		dir := filepath.Join(archiveDir, entries[0].Name())
+		manifest, err := archive.ReadManifest(dir)
+		require.NoError(t, err)
+
+		var ids []string
+		for _, file := range manifest.Files {
+			require.NoError(t, archive.ReadFile(dir, file, manifest.Format, func(doc bson.Raw) error {
+				ids = append(ids, doc.Lookup("_id").StringValue())
+				return nil
+			}))
+		}
+		assert.ElementsMatch(t, []string{"e1", "e3", "e5", "e6", "e8"}, ids)
+	})
+	t.Run("DryRun", func(t *testing.T) {
+		t.Setenv(eventPruneDryRunEnvVar, "true")
+		runGoldenTest(t, pruneEventsName, "dryRun", opts)
+	})
+}
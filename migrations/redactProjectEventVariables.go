@@ -35,21 +35,31 @@ var (
 )
 
 func init() {
-	Registry.registerMigration(redactProjectEventSecretsName, newRedactProjectEventSecrets)
+	// Redacting the GitHub app private keys from the event log is pointless
+	// while the live GitHub app auth documents still hold them.
+	Registry.registerMigration(redactProjectEventSecretsName, newRedactProjectEventSecrets,
+		withRisk(RiskDestructive),
+		withPrerequisites(deleteGitHubAppKeysName),
+		withIDSelection(),
+	)
 }
 
 // redactProjectEventSecrets is a migration to retroactively redact project
 // secret values from project modifications in the event log.
 type redactProjectEventSecrets struct {
 	database string
+	ids      *idSelection
 }
 
 func newRedactProjectEventSecrets(opts MigrationOptions) (Migration, error) {
 	catcher := grip.NewBasicCatcher()
 	catcher.Add(errors.Wrap(opts.validate(), "invalid options"))
+	ids, err := newIDSelection()
+	catcher.Wrap(err, "invalid project ID selection")
 
 	return &redactProjectEventSecrets{
 		database: opts.Database,
+		ids:      ids,
 	}, catcher.Resolve()
 }
 
@@ -93,6 +103,7 @@ func (c *redactProjectEventSecrets) Execute(ctx context.Context, client *mongo.C
 			q["_id"] = bson.M{"$gte": collInfo.startAtID}
 			grip.Infof("Starting at project '%s' in collection '%s'\n", collInfo.startAtID, collInfo.name)
 		}
+		c.ids.apply(q, "_id")
 		// Sort by _id to iterate in a predictable order. This makes it easier to
 		// resume from a specific project if the migration fails partway through.
 		findOpts := options.Find().SetSort(bson.M{"_id": 1}).SetProjection(bson.M{"_id": 1})
@@ -133,6 +144,30 @@ func (c *redactProjectEventSecrets) Execute(ctx context.Context, client *mongo.C
 	return nil
 }
 
+// Preflight requires an index to find each project's modification events and
+// estimates the number of events that may be redacted for the selected
+// projects.
+func (c *redactProjectEventSecrets) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	query := bson.M{
+		event.ResourceTypeKey: event.EventResourceTypeProject,
+		eventTypeKey:          event.EventTypeProjectModified,
+	}
+	c.ids.apply(query, event.ResourceIdKey)
+	count, err := client.Database(c.database).Collection(event.EventCollection).CountDocuments(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "counting project modification events")
+	}
+
+	return &PreflightRequirements{
+		Collections: []string{"project_ref", "repo_ref", event.EventCollection},
+		Indexes: []RequiredIndex{{
+			Collection: event.EventCollection,
+			Fields:     []string{event.ResourceIdKey, event.ResourceTypeKey, eventTypeKey},
+		}},
+		EstimatedDocuments: count,
+	}, nil
+}
+
 func (c *redactProjectEventSecrets) redactForProject(ctx context.Context, client *mongo.Client, projectID string, eventLimit int) error {
 	grip.Infof("Redacting project vars from events for project: %s\n", projectID)
 
@@ -0,0 +1,9 @@
+package migrations
+
+import (
+	"testing"
+)
+
+func TestRedactProjectEventSecrets(t *testing.T) {
+	runGoldenTest(t, redactProjectEventSecretsName, "all", MigrationOptions{})
+}
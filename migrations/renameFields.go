@@ -0,0 +1,363 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	renameFieldsName             = "renameFields"
+	defaultRenameFieldsBatchSize = 1000
+
+	// renameFieldsEnvVar is a comma-separated list of renames, each of the
+	// form 'from->to'.
+	renameFieldsEnvVar = "RENAME_FIELDS"
+	// renameFilterEnvVar is an extended JSON query that limits the documents
+	// whose fields are renamed.
+	renameFilterEnvVar = "RENAME_FILTER"
+
+	renameSeparator = "->"
+	// arrayElementsPathPart marks the array in a path whose elements each
+	// have the field renamed.
+	arrayElementsPathPart = "$[]"
+)
+
+func init() {
+	Registry.registerMigration(renameFieldsName, newRenameFields, withRisk(RiskWrite))
+}
+
+// fieldRename renames the field at one dotted path to another. If the paths
+// go through an array, arrayPath is the path to the array, and from and to
+// are the fields within each of its elements.
+type fieldRename struct {
+	from      string
+	to        string
+	arrayPath string
+}
+
+// oldPath returns the dotted path that matches documents that still have the
+// field to rename.
+func (f fieldRename) oldPath() string {
+	if f.arrayPath != "" {
+		return f.arrayPath + "." + f.from
+	}
+	return f.from
+}
+
+// parentPaths returns the paths of the fields that the rename's paths go
+// through, which must be documents for the field to be renamed.
+func (f fieldRename) parentPaths() []string {
+	paths := []string{f.from, f.to}
+	if f.arrayPath != "" {
+		paths = []string{f.arrayPath}
+	}
+
+	var parents []string
+	seen := map[string]bool{}
+	for _, path := range paths {
+		parts := strings.Split(path, ".")
+		for i := 1; i < len(parts); i++ {
+			parent := strings.Join(parts[:i], ".")
+			if !seen[parent] {
+				seen[parent] = true
+				parents = append(parents, parent)
+			}
+		}
+	}
+	return parents
+}
+
+// renameFields renames fields in a collection in batches of documents. Unlike
+// $rename, it never overwrites a field that already exists at the new path;
+// documents that have one are skipped and reported instead.
+type renameFields struct {
+	database   string
+	collection string
+	batchSize  int
+	filter     bson.M
+	renames    []fieldRename
+}
+
+func newRenameFields(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+	catcher.NewWhen(opts.Collection == "", "collection name not specified")
+
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultRenameFieldsBatchSize
+	}
+
+	renames, err := parseFieldRenames(os.Getenv(renameFieldsEnvVar))
+	catcher.Wrapf(err, "parsing '%s'", renameFieldsEnvVar)
+
+	filter := bson.M{}
+	if filterStr := os.Getenv(renameFilterEnvVar); filterStr != "" {
+		catcher.Wrapf(bson.UnmarshalExtJSON([]byte(filterStr), false, &filter), "parsing '%s'", renameFilterEnvVar)
+	}
+
+	return &renameFields{
+		database:   opts.Database,
+		collection: opts.Collection,
+		batchSize:  opts.BatchSize,
+		filter:     filter,
+		renames:    renames,
+	}, catcher.Resolve()
+}
+
+// parseFieldRenames parses a comma-separated list of 'from->to' renames. A
+// path may contain '$[]' to rename a field in each element of an array, in
+// which case both paths must go through the same array and the field must be
+// directly in the elements.
+func parseFieldRenames(value string) ([]fieldRename, error) {
+	if value == "" {
+		return nil, errors.New("no fields to rename")
+	}
+
+	catcher := grip.NewBasicCatcher()
+	var renames []fieldRename
+	seen := map[string]bool{}
+	for _, spec := range strings.Split(value, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(spec), renameSeparator)
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			catcher.Errorf("rename '%s' must be of the form 'from%sto'", spec, renameSeparator)
+			continue
+		}
+
+		rename, err := newFieldRename(from, to)
+		if err != nil {
+			catcher.Wrapf(err, "rename '%s'", spec)
+			continue
+		}
+		catcher.ErrorfWhen(seen[rename.oldPath()], "field '%s' is renamed more than once", from)
+		seen[rename.oldPath()] = true
+		renames = append(renames, rename)
+	}
+
+	return renames, catcher.Resolve()
+}
+
+func newFieldRename(from, to string) (fieldRename, error) {
+	for _, path := range []string{from, to} {
+		for _, part := range strings.Split(path, ".") {
+			if part == "" {
+				return fieldRename{}, errors.Errorf("path '%s' has an empty field name", path)
+			}
+			if part != arrayElementsPathPart && strings.HasPrefix(part, "$") {
+				return fieldRename{}, errors.Errorf("path '%s' has an operator in a field name", path)
+			}
+		}
+	}
+	if from == to || strings.HasPrefix(to, from+".") || strings.HasPrefix(from, to+".") {
+		return fieldRename{}, errors.New("the paths must be different and one can't contain the other")
+	}
+
+	fromArray, fromField, fromInArray := strings.Cut(from, "."+arrayElementsPathPart+".")
+	toArray, toField, toInArray := strings.Cut(to, "."+arrayElementsPathPart+".")
+	if !fromInArray && !toInArray {
+		if strings.Contains(from, arrayElementsPathPart) || strings.Contains(to, arrayElementsPathPart) {
+			return fieldRename{}, errors.Errorf("'%s' must be followed by the field to rename in each element", arrayElementsPathPart)
+		}
+		return fieldRename{from: from, to: to}, nil
+	}
+
+	if fromArray != toArray || fromInArray != toInArray {
+		return fieldRename{}, errors.New("both paths must rename a field within the elements of the same array")
+	}
+	if strings.Contains(fromArray, arrayElementsPathPart) || strings.Contains(fromField, ".") || strings.Contains(toField, ".") {
+		return fieldRename{}, errors.New("only fields directly in the elements of a single array can be renamed")
+	}
+	return fieldRename{from: fromField, to: toField, arrayPath: fromArray}, nil
+}
+
+// Execute renames the fields in batches of documents, ordered by ID, that
+// match the filter and still have one of the old fields. Renamed documents
+// no longer match, so if the script is interrupted, it resumes where it left
+// off. Documents where one of the paths goes through an array or another
+// value that isn't a document can't be renamed, and documents that already
+// have a field at the new path would lose its value, so they're skipped and
+// reported once the others have been renamed. It fails if there are skipped
+// documents, or if any other documents still have one of the old fields when
+// it's done.
+func (r *renameFields) Execute(ctx context.Context, client *mongo.Client) error {
+	coll := client.Database(r.database).Collection(r.collection)
+
+	skipped, err := r.findUnrenamable(ctx, coll)
+	if err != nil {
+		return err
+	}
+	for _, id := range skipped {
+		grip.Warningf("Skipping document %v in collection '%s' because one of the paths to rename goes through an array or a value that isn't a document, or the new field already exists", id, r.collection)
+	}
+
+	renamed := 0
+	if err := forEachIDBatch(ctx, coll, r.renamableQuery(), r.batchSize, func(ids bson.A) error {
+		if err := r.renameBatch(ctx, coll, ids); err != nil {
+			return err
+		}
+		renamed += len(ids)
+		grip.Infof("Renamed fields in %d documents in collection '%s'", renamed, r.collection)
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "renaming fields in batches")
+	}
+
+	remaining, err := coll.CountDocuments(ctx, r.renamableQuery())
+	if err != nil {
+		return errors.Wrap(err, "counting documents that still have old fields")
+	}
+	if remaining > 0 {
+		return errors.Errorf("%d documents in collection '%s' still have fields that should have been renamed", remaining, r.collection)
+	}
+	if len(skipped) > 0 {
+		return errors.Errorf("skipped %d documents in collection '%s' whose paths go through an array or a value that isn't a document, or whose new fields already exist: %v", len(skipped), r.collection, skipped)
+	}
+
+	return nil
+}
+
+// findUnrenamable returns the IDs of the documents with fields to rename where
+// one of the paths goes through an array or another value that isn't a
+// document, or where the new field already exists.
+func (r *renameFields) findUnrenamable(ctx context.Context, coll *mongo.Collection) ([]interface{}, error) {
+	blocked := r.blockedConditions()
+	if len(blocked) == 0 {
+		return nil, nil
+	}
+	ids, err := coll.Distinct(ctx, "_id", bson.M{"$and": bson.A{r.query(), bson.M{"$or": blocked}}})
+	return ids, errors.Wrap(err, "finding documents whose fields can't be renamed")
+}
+
+// renamableQuery returns the query for documents that have fields to rename
+// and whose fields can all be renamed.
+func (r *renameFields) renamableQuery() bson.M {
+	blocked := r.blockedConditions()
+	if len(blocked) == 0 {
+		return r.query()
+	}
+	return bson.M{"$and": bson.A{r.query(), bson.M{"$nor": blocked}}}
+}
+
+// blockedConditions returns conditions that match documents where a field
+// that one of the paths goes through is an array or another value that isn't a
+// document, or where a field to rename already exists at its new path.
+func (r *renameFields) blockedConditions() bson.A {
+	var blocked bson.A
+	seen := map[string]bool{}
+	for _, rename := range r.renames {
+		if rename.arrayPath != "" {
+			blocked = append(blocked, bson.M{rename.arrayPath: bson.M{"$elemMatch": bson.M{
+				rename.from: bson.M{"$exists": true},
+				rename.to:   bson.M{"$exists": true},
+			}}})
+		} else {
+			blocked = append(blocked, bson.M{
+				rename.from: bson.M{"$exists": true},
+				rename.to:   bson.M{"$exists": true},
+			})
+		}
+		for _, parent := range rename.parentPaths() {
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			blocked = append(blocked,
+				bson.M{parent: bson.M{"$type": "array"}},
+				bson.M{parent: bson.M{"$exists": true, "$not": bson.M{"$type": "object"}}},
+			)
+		}
+	}
+	return blocked
+}
+
+// Preflight estimates the number of documents that have fields to rename.
+func (r *renameFields) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	count, err := client.Database(r.database).Collection(r.collection).CountDocuments(ctx, r.query())
+	if err != nil {
+		return nil, errors.Wrap(err, "counting documents that have fields to rename")
+	}
+
+	return &PreflightRequirements{
+		Collections:        []string{r.collection},
+		EstimatedDocuments: count,
+	}, nil
+}
+
+// query returns the query for documents that match the filter and still have
+// one of the old fields.
+func (r *renameFields) query() bson.M {
+	oldFields := make(bson.A, 0, len(r.renames))
+	for _, rename := range r.renames {
+		oldFields = append(oldFields, bson.M{rename.oldPath(): bson.M{"$exists": true}})
+	}
+	query := bson.M{"$or": oldFields}
+	if len(r.filter) == 0 {
+		return query
+	}
+	return bson.M{"$and": bson.A{r.filter, query}}
+}
+
+// renameBatch renames the fields in the documents with the IDs. The documents
+// must still be renamable, so one that changed since the batch was read is left
+// for the final check to report.
+func (r *renameFields) renameBatch(ctx context.Context, coll *mongo.Collection, ids bson.A) error {
+	batch := bson.M{"$and": bson.A{bson.M{"_id": bson.M{"$in": ids}}, r.renamableQuery()}}
+
+	fieldRenames := bson.M{}
+	for _, rename := range r.renames {
+		if rename.arrayPath == "" {
+			fieldRenames[rename.from] = rename.to
+		}
+	}
+	if len(fieldRenames) > 0 {
+		if _, err := coll.UpdateMany(ctx, batch, bson.M{"$rename": fieldRenames}); err != nil {
+			return errors.Wrap(err, "renaming fields")
+		}
+	}
+
+	for _, rename := range r.renames {
+		if rename.arrayPath == "" {
+			continue
+		}
+		if _, err := coll.UpdateMany(ctx, batch, bson.A{bson.M{"$set": bson.M{rename.arrayPath: renameInElements(rename)}}}); err != nil {
+			return errors.Wrapf(err, "renaming field '%s' in elements of array '%s'", rename.from, rename.arrayPath)
+		}
+	}
+
+	return nil
+}
+
+// renameInElements returns an aggregation expression for the array with the
+// field renamed in each of its elements that are documents. Anything else is
+// left as it is.
+func renameInElements(rename fieldRename) bson.M {
+	array := "$" + rename.arrayPath
+	element := "$$element"
+	return bson.M{"$cond": bson.M{
+		"if": bson.M{"$isArray": array},
+		"then": bson.M{"$map": bson.M{
+			"input": array,
+			"as":    "element",
+			"in": bson.M{"$cond": bson.M{
+				"if": bson.M{"$eq": bson.A{bson.M{"$type": element}, "object"}},
+				// Remove the old field and add its value under the new
+				// name. A missing old field leaves the element unchanged.
+				"then": bson.M{"$mergeObjects": bson.A{
+					bson.M{"$arrayToObject": bson.M{"$filter": bson.M{
+						"input": bson.M{"$objectToArray": element},
+						"cond":  bson.M{"$ne": bson.A{"$$this.k", rename.from}},
+					}}},
+					bson.M{rename.to: element + "." + rename.from},
+				}},
+				"else": element,
+			}},
+		}},
+		"else": array,
+	}}
+}
@@ -0,0 +1,123 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	testFieldRenames = "old_name->display_name, details.old_type->details.type, executions.$[].old_status->executions.$[].status"
+	testRenameFilter = `{"project": {"$ne": "skip"}}`
+)
+
+func TestParseFieldRenames(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		renames, err := parseFieldRenames(testFieldRenames)
+		require.NoError(t, err)
+		assert.Equal(t, []fieldRename{
+			{from: "old_name", to: "display_name"},
+			{from: "details.old_type", to: "details.type"},
+			{from: "old_status", to: "status", arrayPath: "executions"},
+		}, renames)
+	})
+	for name, test := range map[string]struct {
+		value    string
+		expected string
+	}{
+		"Empty":                {value: "", expected: "no fields to rename"},
+		"MissingSeparator":     {value: "a", expected: "must be of the form"},
+		"SamePath":             {value: "a->a", expected: "must be different"},
+		"NestedPath":           {value: "a->a.b", expected: "one can't contain the other"},
+		"Operator":             {value: "a->$b", expected: "has an operator"},
+		"DifferentArrays":      {value: "a.$[].b->c.$[].b", expected: "same array"},
+		"ArrayToField":         {value: "a.$[].b->c", expected: "same array"},
+		"NestedInElement":      {value: "a.$[].b.c->a.$[].d", expected: "directly in the elements"},
+		"NestedArrays":         {value: "a.$[].b.$[].c->a.$[].b.$[].d", expected: "directly in the elements"},
+		"TrailingArrayElement": {value: "a.$[]->b.$[]", expected: "must be followed by the field"},
+		"RenamedTwice":         {value: "a->b,a->c", expected: "renamed more than once"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseFieldRenames(test.value)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expected)
+		})
+	}
+}
+
+func TestRenameFields(t *testing.T) {
+	t.Setenv(renameFieldsEnvVar, testFieldRenames)
+	t.Setenv(renameFilterEnvVar, testRenameFilter)
+	runGoldenTest(t, renameFieldsName, "all", MigrationOptions{Collection: "tasks", BatchSize: 1})
+}
+
+func TestRenameFieldsFailsWhenOldFieldsRemain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(ctx))
+	}()
+	// The field can't be renamed in each element of an array that isn't an
+	// array.
+	_, err := client.Database(db).Collection("tasks").InsertOne(ctx, bson.M{"_id": "t1", "executions": bson.M{"old_status": "success"}})
+	require.NoError(t, err)
+
+	t.Setenv(renameFieldsEnvVar, testFieldRenames)
+	migration, err := Registry.Migration(renameFieldsName, MigrationOptions{Database: db, Collection: "tasks"})
+	require.NoError(t, err)
+
+	err = migration.Execute(ctx, client)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "1 documents in collection 'tasks' still have fields that should have been renamed")
+}
+
+func TestRenameFieldsSkipsUnrenamableDocuments(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(ctx))
+	}()
+	coll := client.Database(db).Collection("tasks")
+	_, err := coll.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "t1", "details": bson.A{bson.M{"old_type": "test"}}},
+		bson.M{"_id": "t2", "details": "test"},
+		bson.M{"_id": "t3", "details": bson.M{"old_type": "test"}},
+		bson.M{"_id": "t4", "details": bson.M{"old_type": "test", "type": "compile"}},
+		bson.M{"_id": "t5", "executions": bson.A{bson.M{"old_status": "success", "status": "failed"}}},
+	})
+	require.NoError(t, err)
+
+	t.Setenv(renameFieldsEnvVar, "details.old_type->details.type, executions.$[].old_status->executions.$[].status")
+	migration, err := Registry.Migration(renameFieldsName, MigrationOptions{Database: db, Collection: "tasks"})
+	require.NoError(t, err)
+
+	err = migration.Execute(ctx, client)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "skipped 3 documents in collection 'tasks'")
+
+	var renamed bson.M
+	require.NoError(t, coll.FindOne(ctx, bson.M{"_id": "t3"}).Decode(&renamed))
+	assert.Equal(t, bson.M{"type": "test"}, renamed["details"], "documents after the skipped one should still be renamed")
+	var skipped bson.M
+	require.NoError(t, coll.FindOne(ctx, bson.M{"_id": "t1"}).Decode(&skipped))
+	assert.Equal(t, bson.A{bson.M{"old_type": "test"}}, skipped["details"])
+
+	// Fields that already exist at the new path aren't overwritten.
+	require.NoError(t, coll.FindOne(ctx, bson.M{"_id": "t4"}).Decode(&skipped))
+	assert.Equal(t, bson.M{"old_type": "test", "type": "compile"}, skipped["details"])
+	require.NoError(t, coll.FindOne(ctx, bson.M{"_id": "t5"}).Decode(&skipped))
+	assert.Equal(t, bson.A{bson.M{"old_status": "success", "status": "failed"}}, skipped["executions"])
+}
+
+func TestFieldRenameParentPaths(t *testing.T) {
+	assert.Empty(t, fieldRename{from: "a", to: "b"}.parentPaths())
+	assert.Equal(t, []string{"a", "a.b", "c"}, fieldRename{from: "a.b.c", to: "c.d"}.parentPaths())
+	assert.Equal(t, []string{"a"}, fieldRename{from: "c", to: "d", arrayPath: "a.b"}.parentPaths())
+}
@@ -0,0 +1,218 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/evergreen-ci/evergreen/model/annotations"
+	"github.com/evergreen-ci/evergreen/model/task"
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	repairHasAnnotationsName         = "repairHasAnnotations"
+	defaultAnnotationRepairWindow    = 30 * 24 * time.Hour
+	defaultAnnotationRepairBatchSize = 1000
+
+	// annotationRepairWindowEnvVar is how far back to check tasks, as a
+	// duration before now. It defaults to 30 days.
+	annotationRepairWindowEnvVar = "ANNOTATION_REPAIR_WINDOW"
+	// annotationRepairProjectsEnvVar is a comma-separated list of the projects
+	// whose tasks are checked. If it's not set, tasks in all projects are
+	// checked.
+	annotationRepairProjectsEnvVar = "ANNOTATION_REPAIR_PROJECTS"
+
+	taskAnnotatedKey = "annotated"
+)
+
+func init() {
+	Registry.registerMigration(repairHasAnnotationsName, newRepairHasAnnotations, withRisk(RiskWrite))
+}
+
+// repairHasAnnotations corrects the has_annotations flag of recent tasks so it
+// matches whether the task's execution has an annotation.
+type repairHasAnnotations struct {
+	database  string
+	batchSize int
+	window    time.Duration
+	projects  []string
+	clock     Clock
+}
+
+func newRepairHasAnnotations(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultAnnotationRepairBatchSize
+	}
+
+	r := &repairHasAnnotations{
+		database:  opts.Database,
+		batchSize: opts.BatchSize,
+		window:    defaultAnnotationRepairWindow,
+		clock:     opts.getClock(),
+	}
+	if windowStr := os.Getenv(annotationRepairWindowEnvVar); windowStr != "" {
+		window, err := time.ParseDuration(windowStr)
+		catcher.Wrapf(err, "can't parse window '%s' as duration", windowStr)
+		catcher.ErrorfWhen(err == nil && window <= 0, "window '%s' must be positive", windowStr)
+		r.window = window
+	}
+	if projectsStr := os.Getenv(annotationRepairProjectsEnvVar); projectsStr != "" {
+		for _, project := range strings.Split(projectsStr, ",") {
+			if project = strings.TrimSpace(project); project != "" {
+				r.projects = append(r.projects, project)
+			}
+		}
+	}
+
+	return r, catcher.Resolve()
+}
+
+// taskAnnotationStatus is a task whose has_annotations flag doesn't match
+// whether its execution has an annotation.
+type taskAnnotationStatus struct {
+	ID        string `bson:"_id"`
+	Execution int    `bson:"execution"`
+	Annotated bool   `bson:"annotated"`
+}
+
+// Execute sets has_annotations on each task in the window whose flag doesn't
+// match whether its execution has an annotation. A task that's missing the
+// flag is treated as not having annotations. The tasks are found in batches
+// ordered by ID, and each batch is read in full before its tasks are updated.
+// The window ends when the script starts, so it doesn't move between batches.
+func (r *repairHasAnnotations) Execute(ctx context.Context, client *mongo.Client) error {
+	coll := client.Database(r.database).Collection(task.Collection)
+	since := r.windowStart()
+	fixed := map[bool]int64{}
+	var lastID string
+	for {
+		batch, err := r.findMismatchBatch(ctx, coll, since, lastID)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		// Each update is limited to the task executions that were checked,
+		// since a task may have been restarted since it was checked, in which
+		// case the flag applies to the new execution.
+		executions := map[bool]bson.A{}
+		for _, status := range batch {
+			executions[status.Annotated] = append(executions[status.Annotated], bson.M{
+				task.IdKey:        status.ID,
+				task.ExecutionKey: status.Execution,
+			})
+		}
+		for annotated, query := range executions {
+			res, err := coll.UpdateMany(ctx, bson.M{"$or": query}, bson.M{"$set": bson.M{task.HasAnnotationsKey: annotated}})
+			if err != nil {
+				return errors.Wrapf(err, "setting has_annotations to %t for batch of tasks", annotated)
+			}
+			fixed[annotated] += res.ModifiedCount
+		}
+		for _, status := range batch {
+			grip.Infof("Set has_annotations to %t for task '%s' execution %d", status.Annotated, status.ID, status.Execution)
+		}
+
+		lastID = batch[len(batch)-1].ID
+		grip.Infof("Checked tasks up to '%s'; set has_annotations to true for %d tasks and to false for %d tasks so far", lastID, fixed[true], fixed[false])
+	}
+
+	grip.Infof("Set has_annotations to true for %d tasks and to false for %d tasks", fixed[true], fixed[false])
+	return nil
+}
+
+// findMismatchBatch returns the next batch of tasks created since the time
+// whose flags are incorrect, with IDs after the last ID.
+func (r *repairHasAnnotations) findMismatchBatch(ctx context.Context, coll *mongo.Collection, since time.Time, lastID string) ([]taskAnnotationStatus, error) {
+	pipeline := append(r.mismatchPipeline(since, lastID), bson.M{"$limit": r.batchSize})
+	cur, err := coll.Aggregate(ctx, pipeline, options.Aggregate().SetBatchSize(int32(r.batchSize)))
+	if err != nil {
+		return nil, errors.Wrap(err, "finding tasks with incorrect annotation flags")
+	}
+	var batch []taskAnnotationStatus
+	if err := cur.All(ctx, &batch); err != nil {
+		return nil, errors.Wrap(err, "iterating over tasks with incorrect annotation flags")
+	}
+	return batch, nil
+}
+
+// Preflight counts the tasks whose flags are incorrect.
+func (r *repairHasAnnotations) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	pipeline := append(r.mismatchPipeline(r.windowStart(), ""), bson.M{"$count": "count"})
+	cur, err := client.Database(r.database).Collection(task.Collection).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "counting tasks with incorrect annotation flags")
+	}
+	var counts []struct {
+		Count int64 `bson:"count"`
+	}
+	if err := cur.All(ctx, &counts); err != nil {
+		return nil, errors.Wrap(err, "iterating over count of tasks with incorrect annotation flags")
+	}
+	var count int64
+	if len(counts) > 0 {
+		count = counts[0].Count
+	}
+
+	return &PreflightRequirements{
+		Collections: []string{task.Collection, annotations.Collection},
+		Indexes: []RequiredIndex{
+			{Collection: annotations.Collection, Fields: []string{annotations.TaskIdKey, annotations.TaskExecutionKey}},
+		},
+		EstimatedDocuments: count,
+	}, nil
+}
+
+// windowStart returns the creation time of the oldest tasks in the window.
+func (r *repairHasAnnotations) windowStart() time.Time {
+	return r.clock.Now().Add(-r.window)
+}
+
+// mismatchPipeline returns an aggregation over tasks created since the time
+// with IDs after the last ID, if it's set, that returns the tasks whose
+// has_annotations flag doesn't match whether there is an annotation for the
+// task's execution.
+func (r *repairHasAnnotations) mismatchPipeline(since time.Time, lastID string) bson.A {
+	match := bson.M{task.CreateTimeKey: bson.M{"$gte": since}}
+	if len(r.projects) > 0 {
+		match[task.ProjectKey] = bson.M{"$in": r.projects}
+	}
+	if lastID != "" {
+		match[task.IdKey] = bson.M{"$gt": lastID}
+	}
+
+	return bson.A{
+		bson.M{"$match": match},
+		bson.M{"$sort": bson.M{task.IdKey: 1}},
+		bson.M{"$lookup": bson.M{
+			"from": annotations.Collection,
+			"let":  bson.M{"task_id": "$" + task.IdKey, "execution": "$" + task.ExecutionKey},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$and": bson.A{
+					bson.M{"$eq": bson.A{"$" + annotations.TaskIdKey, "$$task_id"}},
+					bson.M{"$eq": bson.A{"$" + annotations.TaskExecutionKey, "$$execution"}},
+				}}}},
+				bson.M{"$limit": 1},
+				bson.M{"$project": bson.M{"_id": 1}},
+			},
+			"as": taskAnnotatedKey,
+		}},
+		bson.M{"$project": bson.M{
+			task.ExecutionKey:      1,
+			task.HasAnnotationsKey: bson.M{"$ifNull": bson.A{"$" + task.HasAnnotationsKey, false}},
+			taskAnnotatedKey:       bson.M{"$gt": bson.A{bson.M{"$size": "$" + taskAnnotatedKey}, 0}},
+		}},
+		bson.M{"$match": bson.M{"$expr": bson.M{"$ne": bson.A{"$" + task.HasAnnotationsKey, "$" + taskAnnotatedKey}}}},
+	}
+}
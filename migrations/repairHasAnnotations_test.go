@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/testdata"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAnnotationRepairProjects = "mongodb-mongo-v8.0, mongodb-mongo-master"
+
+var testAnnotationRepairNow = time.Date(2024, 06, 30, 0, 0, 0, 0, time.UTC)
+
+func TestNewRepairHasAnnotations(t *testing.T) {
+	opts := MigrationOptions{Database: "db"}
+
+	t.Run("Defaults", func(t *testing.T) {
+		migration, err := newRepairHasAnnotations(opts)
+		require.NoError(t, err)
+		assert.Equal(t, defaultAnnotationRepairWindow, migration.(*repairHasAnnotations).window)
+		assert.Empty(t, migration.(*repairHasAnnotations).projects)
+	})
+	t.Run("WindowAndProjects", func(t *testing.T) {
+		t.Setenv(annotationRepairWindowEnvVar, "48h")
+		t.Setenv(annotationRepairProjectsEnvVar, testAnnotationRepairProjects)
+		migration, err := newRepairHasAnnotations(opts)
+		require.NoError(t, err)
+		assert.Equal(t, 48*time.Hour, migration.(*repairHasAnnotations).window)
+		assert.Equal(t, []string{"mongodb-mongo-v8.0", "mongodb-mongo-master"}, migration.(*repairHasAnnotations).projects)
+	})
+	t.Run("NegativeWindow", func(t *testing.T) {
+		t.Setenv(annotationRepairWindowEnvVar, "-48h")
+		_, err := newRepairHasAnnotations(opts)
+		assert.Error(t, err)
+	})
+}
+
+func TestRepairHasAnnotations(t *testing.T) {
+	t.Setenv(annotationRepairProjectsEnvVar, testAnnotationRepairProjects)
+	runGoldenTest(t, repairHasAnnotationsName, "all", MigrationOptions{
+		BatchSize: 2,
+		Clock:     testdata.NewFakeClock(testAnnotationRepairNow),
+	})
+}
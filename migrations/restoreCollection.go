@@ -0,0 +1,224 @@
+package migrations
+
+import (
+	"context"
+	"os"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	restoreCollectionName   = "restoreCollection"
+	defaultRestoreBatchSize = 1000
+	duplicateKeyErrorCode   = 11000
+	restoreModeInsert       = "insert"
+	restoreModeUpsert       = "upsert"
+
+	// restoreDirEnvVar is the archive directory to restore from.
+	restoreDirEnvVar = "RESTORE_DIR"
+	// restoreModeEnvVar is 'insert' to only insert documents that don't
+	// already exist, or 'upsert' to replace existing documents with the
+	// archived ones. It defaults to 'insert'.
+	restoreModeEnvVar = "RESTORE_MODE"
+)
+
+func init() {
+	Registry.registerMigration(restoreCollectionName, newRestoreCollection, withRisk(RiskDestructive), withCreatesTarget())
+}
+
+// restoreCollection loads the documents in an archive written by
+// archiveCollection back into a collection.
+type restoreCollection struct {
+	database string
+	// collection is the collection to restore to. If it's not set, the
+	// archived collection is used.
+	collection string
+	batchSize  int
+	dir        string
+	mode       string
+}
+
+func newRestoreCollection(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+
+	if opts.BatchSize == 0 {
+		opts.BatchSize = defaultRestoreBatchSize
+	}
+
+	r := &restoreCollection{
+		database:   opts.Database,
+		collection: opts.Collection,
+		batchSize:  opts.BatchSize,
+		dir:        os.Getenv(restoreDirEnvVar),
+		mode:       restoreModeInsert,
+	}
+	catcher.ErrorfWhen(r.dir == "", "expected environment variable '%s' was not specified", restoreDirEnvVar)
+	if mode := os.Getenv(restoreModeEnvVar); mode != "" {
+		r.mode = mode
+	}
+	catcher.ErrorfWhen(r.mode != restoreModeInsert && r.mode != restoreModeUpsert, "'%s' must be '%s' or '%s'", restoreModeEnvVar, restoreModeInsert, restoreModeUpsert)
+
+	return r, catcher.Resolve()
+}
+
+// restoreCounts are the outcomes of restoring documents.
+type restoreCounts struct {
+	inserted  int64
+	replaced  int64
+	unchanged int64
+	conflicts int64
+}
+
+// Execute checks every archive file against the manifest before restoring
+// any of them, then writes the archived documents in batches. In insert mode,
+// documents that already exist are left as they are and reported as conflicts
+// if they differ from the archived ones. In upsert mode, they're replaced.
+// Rerunning the script restores the archive again, which doesn't change
+// documents that were already restored.
+func (r *restoreCollection) Execute(ctx context.Context, client *mongo.Client) error {
+	manifest, err := archive.ReadManifest(r.dir)
+	if err != nil {
+		return errors.Wrapf(err, "reading manifest in '%s'", r.dir)
+	}
+	for _, file := range manifest.Files {
+		if err := archive.VerifyFile(r.dir, file); err != nil {
+			return errors.Wrap(err, "verifying archive")
+		}
+	}
+
+	collection := r.targetCollection(manifest)
+	coll := client.Database(r.database).Collection(collection)
+	counts := &restoreCounts{}
+	for _, file := range manifest.Files {
+		var read int64
+		batch := make([]bson.Raw, 0, r.batchSize)
+		if err := archive.ReadFile(r.dir, file, manifest.Format, func(doc bson.Raw) error {
+			read++
+			batch = append(batch, doc)
+			if len(batch) < r.batchSize {
+				return nil
+			}
+			err := r.writeBatch(ctx, coll, batch, counts)
+			batch = batch[:0]
+			return err
+		}); err != nil {
+			return err
+		}
+		if len(batch) > 0 {
+			if err := r.writeBatch(ctx, coll, batch, counts); err != nil {
+				return err
+			}
+		}
+		if read != file.Documents {
+			return errors.Errorf("archive file '%s' has %d documents, but the manifest says it has %d", file.Name, read, file.Documents)
+		}
+		grip.Infof("Restored archive file '%s' to collection '%s'", file.Name, collection)
+	}
+
+	grip.Infof("Restored %d documents to collection '%s': %d inserted, %d replaced, %d unchanged, %d conflicts", manifest.Documents, collection, counts.inserted, counts.replaced, counts.unchanged, counts.conflicts)
+	return nil
+}
+
+// Preflight estimates the number of documents that may be replaced, which is
+// none in insert mode.
+func (r *restoreCollection) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	manifest, err := archive.ReadManifest(r.dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading manifest in '%s'", r.dir)
+	}
+
+	reqs := &PreflightRequirements{}
+	if r.mode == restoreModeUpsert {
+		reqs.EstimatedDocuments = manifest.Documents
+	}
+	return reqs, nil
+}
+
+func (r *restoreCollection) targetCollection(manifest *archive.Manifest) string {
+	if r.collection != "" {
+		return r.collection
+	}
+	return manifest.Collection
+}
+
+func (r *restoreCollection) writeBatch(ctx context.Context, coll *mongo.Collection, batch []bson.Raw, counts *restoreCounts) error {
+	models := make([]mongo.WriteModel, 0, len(batch))
+	for _, doc := range batch {
+		if r.mode == restoreModeUpsert {
+			models = append(models, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"_id": doc.Lookup("_id")}).
+				SetReplacement(doc).
+				SetUpsert(true))
+		} else {
+			models = append(models, mongo.NewInsertOneModel().SetDocument(doc))
+		}
+	}
+
+	res, err := coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if res != nil {
+		counts.inserted += res.InsertedCount + res.UpsertedCount
+		counts.replaced += res.ModifiedCount
+		counts.unchanged += res.MatchedCount - res.ModifiedCount
+	}
+	if err == nil {
+		return nil
+	}
+
+	bulkErr, ok := err.(mongo.BulkWriteException)
+	if !ok || r.mode != restoreModeInsert || bulkErr.WriteConcernError != nil {
+		return errors.Wrap(err, "writing batch of archived documents")
+	}
+	var existing []bson.Raw
+	for _, writeErr := range bulkErr.WriteErrors {
+		if writeErr.Code != duplicateKeyErrorCode {
+			return errors.Wrap(err, "writing batch of archived documents")
+		}
+		existing = append(existing, batch[writeErr.Index])
+	}
+	return errors.Wrap(r.reportConflicts(ctx, coll, existing, counts), "checking documents that already exist")
+}
+
+// reportConflicts compares archived documents that couldn't be inserted with
+// the documents that already exist with their IDs, and reports the ones that
+// differ.
+func (r *restoreCollection) reportConflicts(ctx context.Context, coll *mongo.Collection, archived []bson.Raw, counts *restoreCounts) error {
+	ids := make(bson.A, 0, len(archived))
+	for _, doc := range archived {
+		ids = append(ids, doc.Lookup("_id"))
+	}
+	cur, err := coll.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return errors.Wrap(err, "finding existing documents")
+	}
+	var existingDocs []bson.Raw
+	if err := cur.All(ctx, &existingDocs); err != nil {
+		return errors.Wrap(err, "iterating over existing documents")
+	}
+	existing := make(map[string]bson.Raw, len(existingDocs))
+	for _, doc := range existingDocs {
+		existing[doc.Lookup("_id").String()] = doc
+	}
+
+	for _, doc := range archived {
+		id := doc.Lookup("_id")
+		if current, ok := existing[id.String()]; ok {
+			equal, err := equalDocuments(current, doc, false)
+			if err != nil {
+				return errors.Wrapf(err, "comparing archived document %s with the existing document", id)
+			}
+			if equal {
+				counts.unchanged++
+				continue
+			}
+		}
+		counts.conflicts++
+		grip.Warningf("Archived document %s conflicts with an existing document in collection '%s'; keeping the existing document", id, coll.Name())
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// writeTestArchive writes the documents in testdata/restoreCollection to an
+// archive of the tasks collection in a temporary directory and returns the
+// directory.
+func writeTestArchive(t *testing.T, format archive.Format) string {
+	dir := t.TempDir()
+	writer, err := archive.NewWriter(dir, "tasks", format, 150)
+	require.NoError(t, err)
+
+	f, err := os.Open(path.Join("testdata", restoreCollectionName, "archived.jsonl"))
+	require.NoError(t, err)
+	defer f.Close()
+	var count int64
+	require.NoError(t, archive.Decode(f, archive.FormatJSONL, func(doc bson.Raw) error {
+		count++
+		return writer.Write(doc)
+	}))
+
+	files, err := writer.Close()
+	require.NoError(t, err)
+	require.NoError(t, archive.WriteManifest(dir, &archive.Manifest{
+		Database:   "mci",
+		Collection: "tasks",
+		Format:     format,
+		Documents:  count,
+		Files:      files,
+	}))
+	return dir
+}
+
+func TestRestoreCollection(t *testing.T) {
+	for _, format := range []archive.Format{archive.FormatJSONL, archive.FormatBSON} {
+		t.Run(string(format), func(t *testing.T) {
+			t.Run("Insert", func(t *testing.T) {
+				t.Setenv(restoreDirEnvVar, writeTestArchive(t, format))
+				runGoldenTest(t, restoreCollectionName, restoreModeInsert, MigrationOptions{BatchSize: 2})
+			})
+			t.Run("Upsert", func(t *testing.T) {
+				t.Setenv(restoreDirEnvVar, writeTestArchive(t, format))
+				t.Setenv(restoreModeEnvVar, restoreModeUpsert)
+				runGoldenTest(t, restoreCollectionName, restoreModeUpsert, MigrationOptions{BatchSize: 2})
+			})
+		})
+	}
+}
+
+func TestRestoreCollectionVerifiesArchive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(ctx))
+	}()
+
+	dir := writeTestArchive(t, archive.FormatJSONL)
+	manifest, err := archive.ReadManifest(dir)
+	require.NoError(t, err)
+	require.Greater(t, len(manifest.Files), 1)
+	// Corrupt the last file, so a restore that didn't check every file first
+	// would have written the earlier ones.
+	lastFile := filepath.Join(dir, manifest.Files[len(manifest.Files)-1].Name)
+	data, err := os.ReadFile(lastFile)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(lastFile, append(data, 0), 0644))
+
+	t.Setenv(restoreDirEnvVar, dir)
+	migration, err := Registry.Migration(restoreCollectionName, MigrationOptions{Database: db})
+	require.NoError(t, err)
+	err = migration.Execute(ctx, client)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "verifying archive")
+
+	count, err := client.Database(db).Collection("tasks").CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestRestoreCollectionReportConflicts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(ctx))
+	}()
+	coll := client.Database(db).Collection("tasks")
+	_, err := coll.InsertMany(ctx, []interface{}{
+		bson.D{{Key: "_id", Value: "reordered"}, {Key: "execution", Value: int32(0)}, {Key: "status", Value: "failed"}},
+		bson.D{{Key: "_id", Value: "changed"}, {Key: "status", Value: "success"}, {Key: "execution", Value: int32(0)}},
+	})
+	require.NoError(t, err)
+
+	var archived []bson.Raw
+	for _, doc := range []bson.D{
+		{{Key: "_id", Value: "reordered"}, {Key: "status", Value: "failed"}, {Key: "execution", Value: int32(0)}},
+		{{Key: "_id", Value: "changed"}, {Key: "status", Value: "failed"}, {Key: "execution", Value: int32(0)}},
+	} {
+		raw, err := bson.Marshal(doc)
+		require.NoError(t, err)
+		archived = append(archived, raw)
+	}
+
+	counts := &restoreCounts{}
+	require.NoError(t, (&restoreCollection{}).reportConflicts(ctx, coll, archived, counts))
+	assert.EqualValues(t, 1, counts.unchanged, "documents with the same fields in a different order should be unchanged")
+	assert.EqualValues(t, 1, counts.conflicts)
+}
@@ -0,0 +1,139 @@
+package migrations
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RunOptions are the options for running a registered migration.
+type RunOptions struct {
+	Name    string
+	Options MigrationOptions
+	Safety  SafetyOptions
+	// IgnoreDependencies runs the migration even if its prerequisites haven't
+	// succeeded against the database.
+	IgnoreDependencies bool
+	// Plan and Step identify the plan step the migration is run for, if any.
+	Plan string
+	Step string
+}
+
+// Check runs only the preflight checks for the migration and logs what they
+// found.
+func Check(ctx context.Context, client *mongo.Client, name string, opts MigrationOptions) (*PreflightReport, error) {
+	migration, err := Registry.Migration(name, opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting migration script")
+	}
+	return preflight(ctx, client, name, opts, migration)
+}
+
+// Run runs the migration once its preflight and safety checks pass, and
+// records the run in the database's run history. Versioned migrations can only
+// be applied with Up.
+func Run(ctx context.Context, client *mongo.Client, runOpts RunOptions) error {
+	info, err := Registry.Info(runOpts.Name)
+	if err != nil {
+		return errors.Wrap(err, "getting migration info")
+	}
+	if info.Version != 0 {
+		return errors.Errorf("migration '%s' is schema version %d and must be applied with up", runOpts.Name, info.Version)
+	}
+	return run(ctx, client, runOpts)
+}
+
+func run(ctx context.Context, client *mongo.Client, runOpts RunOptions) error {
+	migration, err := Registry.Migration(runOpts.Name, runOpts.Options)
+	if err != nil {
+		return errors.Wrap(err, "getting migration script")
+	}
+
+	report, err := preflight(ctx, client, runOpts.Name, runOpts.Options, migration)
+	if err != nil {
+		return err
+	}
+	if err := CheckSafety(runOpts.Name, runOpts.Options, report, runOpts.Safety); err != nil {
+		return errors.Wrap(err, "checking script is safe to run")
+	}
+
+	history := NewRunHistory(client.Database(runOpts.Options.Database), runOpts.Options.getClock())
+	if err := checkPrerequisites(ctx, history, runOpts.Name, runOpts.IgnoreDependencies); err != nil {
+		return err
+	}
+
+	record, err := history.Start(ctx, RunRecord{
+		Script: runOpts.Name,
+		Plan:   runOpts.Plan,
+		Step:   runOpts.Step,
+	})
+	if err != nil {
+		return errors.Wrap(err, "recording start of run")
+	}
+
+	runErr := migration.Execute(ctx, client)
+	// Record the outcome even if the run was interrupted.
+	catcher := grip.NewBasicCatcher()
+	catcher.Add(runErr)
+	catcher.Wrap(history.Finish(context.WithoutCancel(ctx), record, runErr), "recording outcome of run")
+	return catcher.Resolve()
+}
+
+// checkPrerequisites checks that the migration's prerequisites have succeeded
+// against the database. If ignore is set, unmet prerequisites are only logged.
+func checkPrerequisites(ctx context.Context, history *RunHistory, name string, ignore bool) error {
+	info, err := Registry.Info(name)
+	if err != nil {
+		return errors.Wrap(err, "getting migration info")
+	}
+
+	var unmet []string
+	for _, prereq := range info.Prerequisites {
+		succeeded, err := history.ScriptSucceeded(ctx, prereq)
+		if err != nil {
+			return errors.Wrapf(err, "checking run history for prerequisite '%s'", prereq)
+		}
+		if !succeeded {
+			unmet = append(unmet, prereq)
+		}
+	}
+	if len(unmet) == 0 {
+		return nil
+	}
+
+	if ignore {
+		grip.Warningf("Ignoring prerequisites of script '%s' that have not succeeded against database '%s': %s", name, history.coll.Database().Name(), strings.Join(unmet, ", "))
+		return nil
+	}
+	return errors.Errorf("script '%s' requires %s to have succeeded against database '%s' first", name, strings.Join(unmet, ", "), history.coll.Database().Name())
+}
+
+// preflight checks that the database is ready for the migration and logs what
+// it found.
+func preflight(ctx context.Context, client *mongo.Client, name string, opts MigrationOptions, migration Migration) (*PreflightReport, error) {
+	info, err := Registry.Info(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting migration info")
+	}
+	grip.Infof("Script '%s' is %s", name, info.Risk)
+
+	report, err := RunPreflight(ctx, client, info, opts, migration)
+	if report != nil {
+		grip.Infof("Server version: %s", report.ServerVersion)
+		grip.Infof("Server topology: %s", report.Topology)
+		if report.Requirements != nil {
+			grip.Infof("Estimated documents affected: %d", report.Requirements.EstimatedDocuments)
+		} else {
+			grip.Info("Script does not estimate the documents it affects")
+		}
+	}
+	if err != nil {
+		return report, errors.Wrap(err, "preflight checks failed")
+	}
+
+	grip.Info("Preflight checks passed")
+	return report, nil
+}
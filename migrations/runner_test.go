@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckPrerequisites(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(ctx))
+	}()
+	history := NewRunHistory(client.Database(db), nil)
+
+	t.Run("UnmetPrerequisite", func(t *testing.T) {
+		err := checkPrerequisites(ctx, history, redactProjectEventSecretsName, false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "requires deleteGitHubAppKeys to have succeeded against database '"+db+"'")
+	})
+	t.Run("IgnoredPrerequisite", func(t *testing.T) {
+		assert.NoError(t, checkPrerequisites(ctx, history, redactProjectEventSecretsName, true))
+	})
+	t.Run("FailedPrerequisite", func(t *testing.T) {
+		record, err := history.Start(ctx, RunRecord{Script: deleteGitHubAppKeysName})
+		require.NoError(t, err)
+		require.NoError(t, history.Finish(ctx, record, assert.AnError))
+
+		assert.Error(t, checkPrerequisites(ctx, history, redactProjectEventSecretsName, false))
+	})
+	t.Run("MetPrerequisite", func(t *testing.T) {
+		record, err := history.Start(ctx, RunRecord{Script: deleteGitHubAppKeysName})
+		require.NoError(t, err)
+		require.NoError(t, history.Finish(ctx, record, nil))
+
+		assert.NoError(t, checkPrerequisites(ctx, history, redactProjectEventSecretsName, false))
+	})
+	t.Run("NoPrerequisites", func(t *testing.T) {
+		assert.NoError(t, checkPrerequisites(ctx, history, helloWorld, false))
+	})
+}
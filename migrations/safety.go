@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// SafetyOptions are the operator's explicit approvals to run a destructive
+// migration.
+type SafetyOptions struct {
+	// Confirm must be ConfirmToken for the migration and database.
+	Confirm string
+	// MaxAffectedDocuments is the most documents the migration may be
+	// estimated to affect. It must be set unless the migration is estimated
+	// to affect no documents.
+	MaxAffectedDocuments int64
+}
+
+// ConfirmToken returns the token that confirms running the migration against
+// the database.
+func ConfirmToken(name, database string) string {
+	return fmt.Sprintf("%s@%s", name, database)
+}
+
+// CheckSafety checks that a destructive migration has been explicitly
+// confirmed for the target database and that the preflight estimate of the
+// documents it affects is within the allowed maximum. A migration that's
+// estimated to affect no documents, such as one that only changes indexes,
+// doesn't need a maximum. Migrations that aren't destructive are always
+// allowed.
+func CheckSafety(name string, opts MigrationOptions, report *PreflightReport, safety SafetyOptions) error {
+	info, err := Registry.Info(name)
+	if err != nil {
+		return err
+	}
+	if info.Risk != RiskDestructive {
+		return nil
+	}
+
+	if expected := ConfirmToken(name, opts.Database); safety.Confirm != expected {
+		return errors.Errorf("migration '%s' is destructive and must be confirmed with '%s'", name, expected)
+	}
+
+	if report == nil || report.Requirements == nil {
+		return errors.Errorf("migration '%s' is destructive but does not estimate the documents it affects, so the maximum can't be checked", name)
+	}
+	estimated := report.Requirements.EstimatedDocuments
+	if estimated == 0 {
+		return nil
+	}
+	if safety.MaxAffectedDocuments <= 0 {
+		return errors.Errorf("migration '%s' is destructive and requires a maximum number of affected documents", name)
+	}
+	if estimated > safety.MaxAffectedDocuments {
+		return errors.Errorf("migration '%s' is estimated to affect %d documents, which exceeds the maximum of %d", name, estimated, safety.MaxAffectedDocuments)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSafety(t *testing.T) {
+	opts := MigrationOptions{Database: "mci"}
+	report := &PreflightReport{Requirements: &PreflightRequirements{EstimatedDocuments: 10}}
+
+	t.Run("ReadOnlyMigrationNeedsNoConfirmation", func(t *testing.T) {
+		assert.NoError(t, CheckSafety(helloWorld, opts, nil, SafetyOptions{}))
+	})
+	t.Run("DestructiveMigrationWithoutConfirmation", func(t *testing.T) {
+		err := CheckSafety(deleteProjectVarsName, opts, report, SafetyOptions{MaxAffectedDocuments: 10})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must be confirmed with 'deleteProjectVars@mci'")
+	})
+	t.Run("ConfirmationForDifferentDatabase", func(t *testing.T) {
+		err := CheckSafety(deleteProjectVarsName, opts, report, SafetyOptions{
+			Confirm:              "deleteProjectVars@mci_staging",
+			MaxAffectedDocuments: 10,
+		})
+		assert.Error(t, err)
+	})
+	t.Run("DestructiveMigrationWithoutMaximum", func(t *testing.T) {
+		err := CheckSafety(deleteProjectVarsName, opts, report, SafetyOptions{Confirm: "deleteProjectVars@mci"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "requires a maximum number of affected documents")
+	})
+	t.Run("NoDocumentsAffectedNeedsNoMaximum", func(t *testing.T) {
+		assert.NoError(t, CheckSafety(deleteProjectVarsName, opts, &PreflightReport{Requirements: &PreflightRequirements{}}, SafetyOptions{Confirm: "deleteProjectVars@mci"}))
+	})
+	t.Run("IndexMigrationNeedsNoMaximum", func(t *testing.T) {
+		migration := &manageIndexes{collection: "tasks"}
+		requirements, err := migration.Preflight(context.Background(), nil)
+		require.NoError(t, err)
+		assert.NoError(t, CheckSafety(manageIndexesName, opts, &PreflightReport{Requirements: requirements}, SafetyOptions{Confirm: "manageIndexes@mci"}))
+	})
+	t.Run("EstimateExceedsMaximum", func(t *testing.T) {
+		err := CheckSafety(deleteProjectVarsName, opts, report, SafetyOptions{
+			Confirm:              "deleteProjectVars@mci",
+			MaxAffectedDocuments: 9,
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "estimated to affect 10 documents, which exceeds the maximum of 9")
+	})
+	t.Run("NoEstimate", func(t *testing.T) {
+		err := CheckSafety(deleteProjectVarsName, opts, &PreflightReport{}, SafetyOptions{
+			Confirm:              "deleteProjectVars@mci",
+			MaxAffectedDocuments: 10,
+		})
+		assert.Error(t, err)
+	})
+	t.Run("NoEstimateOrMaximum", func(t *testing.T) {
+		err := CheckSafety(deleteProjectVarsName, opts, &PreflightReport{}, SafetyOptions{Confirm: "deleteProjectVars@mci"})
+		assert.Error(t, err)
+	})
+	t.Run("ConfirmedWithinMaximum", func(t *testing.T) {
+		assert.NoError(t, CheckSafety(deleteProjectVarsName, opts, report, SafetyOptions{
+			Confirm:              "deleteProjectVars@mci",
+			MaxAffectedDocuments: 10,
+		}))
+	})
+}
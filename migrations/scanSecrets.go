@@ -0,0 +1,354 @@
+package migrations
+
+import (
+	"context"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	scanSecretsName              = "scanSecrets"
+	defaultSecretScanReportLimit = 100
+	defaultSecretScanMinEntropy  = 3.5
+	defaultSecretScanMinLength   = 16
+	defaultSecretScanKeyNames    = "token,secret"
+	secretScanTargetSep          = ","
+	secretKindPEMPrivateKey      = "PEM private key"
+	secretKindAWSAccessKeyID     = "AWS access key ID"
+	secretKindHighEntropyValue   = "high-entropy value"
+
+	// secretScanTargetsEnvVar is a comma-separated list of the collections to
+	// scan, each optionally followed by the path of the field to scan in it,
+	// e.g. 'events.data,project_ref,github_app_auth.private_key'. A
+	// collection without a path is scanned in full. It defaults to the whole
+	// of the collection given by --collection.
+	secretScanTargetsEnvVar = "SECRET_SCAN_TARGETS"
+	// secretScanKeyNamesEnvVar is a comma-separated list of the substrings of
+	// field names whose values are checked for high entropy. It defaults to
+	// 'token,secret'.
+	secretScanKeyNamesEnvVar = "SECRET_SCAN_KEY_NAMES"
+	// secretScanMinEntropyEnvVar is the Shannon entropy, in bits per
+	// character, from which a value under one of the key names is reported.
+	secretScanMinEntropyEnvVar = "SECRET_SCAN_MIN_ENTROPY"
+	// secretScanMinLengthEnvVar is the length from which a value under one of
+	// the key names is checked for high entropy.
+	secretScanMinLengthEnvVar = "SECRET_SCAN_MIN_LENGTH"
+	// secretScanReportLimitEnvVar is the most findings to report by document
+	// ID and path. Every finding is counted regardless.
+	secretScanReportLimitEnvVar = "SECRET_SCAN_REPORT_LIMIT"
+)
+
+var (
+	pemPrivateKeyPattern  = regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY-----`)
+	awsAccessKeyIDPattern = regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)
+)
+
+func init() {
+	Registry.registerMigration(scanSecretsName, newScanSecrets, withRisk(RiskReadOnly))
+}
+
+// secretScanTarget is a collection to scan for secrets and the paths of the
+// fields to scan in it, which are empty to scan whole documents.
+type secretScanTarget struct {
+	collection string
+	paths      []string
+}
+
+// parseSecretScanTargets parses a comma-separated list of collections and
+// optional field paths, grouping the paths by collection.
+func parseSecretScanTargets(targetsStr string) ([]secretScanTarget, error) {
+	catcher := grip.NewBasicCatcher()
+	var targets []secretScanTarget
+	byCollection := map[string]int{}
+	for _, targetStr := range strings.Split(targetsStr, secretScanTargetSep) {
+		targetStr = strings.TrimSpace(targetStr)
+		if targetStr == "" {
+			continue
+		}
+		collection, path, hasPath := strings.Cut(targetStr, ".")
+		if collection == "" || (hasPath && path == "") {
+			catcher.Errorf("target '%s' must be written as <collection>[.<path>]", targetStr)
+			continue
+		}
+
+		i, ok := byCollection[collection]
+		if !ok {
+			i = len(targets)
+			byCollection[collection] = i
+			targets = append(targets, secretScanTarget{collection: collection})
+		}
+		targets[i].paths = append(targets[i].paths, path)
+	}
+	catcher.NewWhen(len(targets) == 0 && !catcher.HasErrors(), "no targets specified")
+
+	for i := range targets {
+		targets[i].paths = coveringPaths(targets[i].paths)
+	}
+	return targets, catcher.Resolve()
+}
+
+// coveringPaths returns the paths that aren't within another of the paths,
+// since a projection can't include both a field and one of its subfields. An
+// empty path covers every other path.
+func coveringPaths(paths []string) []string {
+	sort.Strings(paths)
+	var covering []string
+	for _, path := range paths {
+		if path == "" {
+			return nil
+		}
+		if len(covering) > 0 {
+			last := covering[len(covering)-1]
+			if path == last || strings.HasPrefix(path, last+".") {
+				continue
+			}
+		}
+		covering = append(covering, path)
+	}
+	return covering
+}
+
+// secretFinding is a value that looks like a secret. It deliberately doesn't
+// hold the value, so the value can't be reported.
+type secretFinding struct {
+	collection string
+	id         bson.RawValue
+	path       string
+	kind       string
+}
+
+// scanSecrets reports the fields that look like they hold secrets, without
+// printing their values.
+type scanSecrets struct {
+	database    string
+	batchSize   int
+	targets     []secretScanTarget
+	keyNames    []string
+	minEntropy  float64
+	minLength   int
+	reportLimit int
+}
+
+func newScanSecrets(opts MigrationOptions) (Migration, error) {
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrap(opts.validate(), "invalid options")
+
+	s := &scanSecrets{
+		database:    opts.Database,
+		batchSize:   opts.BatchSize,
+		keyNames:    splitFieldList(defaultSecretScanKeyNames),
+		minEntropy:  defaultSecretScanMinEntropy,
+		minLength:   defaultSecretScanMinLength,
+		reportLimit: defaultSecretScanReportLimit,
+	}
+
+	if targetsStr := os.Getenv(secretScanTargetsEnvVar); targetsStr != "" {
+		targets, err := parseSecretScanTargets(targetsStr)
+		catcher.Wrapf(err, "parsing '%s'", secretScanTargetsEnvVar)
+		s.targets = targets
+	} else if opts.Collection != "" {
+		s.targets = []secretScanTarget{{collection: opts.Collection}}
+	} else {
+		catcher.Errorf("either a collection or environment variable '%s' must be specified", secretScanTargetsEnvVar)
+	}
+
+	if keyNamesStr := os.Getenv(secretScanKeyNamesEnvVar); keyNamesStr != "" {
+		s.keyNames = splitFieldList(strings.ToLower(keyNamesStr))
+	}
+	if minEntropyStr := os.Getenv(secretScanMinEntropyEnvVar); minEntropyStr != "" {
+		minEntropy, err := strconv.ParseFloat(minEntropyStr, 64)
+		catcher.Wrapf(err, "parsing '%s'", secretScanMinEntropyEnvVar)
+		catcher.ErrorfWhen(err == nil && minEntropy <= 0, "'%s' must be positive", secretScanMinEntropyEnvVar)
+		s.minEntropy = minEntropy
+	}
+	if minLengthStr := os.Getenv(secretScanMinLengthEnvVar); minLengthStr != "" {
+		minLength, err := strconv.Atoi(minLengthStr)
+		catcher.Wrapf(err, "parsing '%s'", secretScanMinLengthEnvVar)
+		catcher.ErrorfWhen(err == nil && minLength <= 0, "'%s' must be positive", secretScanMinLengthEnvVar)
+		s.minLength = minLength
+	}
+	if limitStr := os.Getenv(secretScanReportLimitEnvVar); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		catcher.Wrapf(err, "parsing '%s'", secretScanReportLimitEnvVar)
+		catcher.ErrorfWhen(err == nil && limit < 0, "'%s' can't be negative", secretScanReportLimitEnvVar)
+		s.reportLimit = limit
+	}
+
+	return s, catcher.Resolve()
+}
+
+// Execute scans each target and reports the ID of each document and the path
+// of each field that looks like it holds a secret, up to the report limit,
+// followed by the number of findings of each kind in each collection.
+func (s *scanSecrets) Execute(ctx context.Context, client *mongo.Client) error {
+	var reported int
+	for _, target := range s.targets {
+		counts := map[string]int{}
+		err := s.scanCollection(ctx, client.Database(s.database).Collection(target.collection), target.paths, func(finding secretFinding) {
+			counts[finding.kind]++
+			reported++
+			if reported <= s.reportLimit {
+				grip.Warningf("Document %s in collection '%s' has a possible %s at '%s'", finding.id, finding.collection, finding.kind, finding.path)
+			}
+		})
+		if err != nil {
+			return errors.Wrapf(err, "scanning collection '%s'", target.collection)
+		}
+
+		kinds := make([]string, 0, len(counts))
+		for kind := range counts {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			grip.Warningf("Found %d possible %s values in collection '%s'", counts[kind], kind, target.collection)
+		}
+		if len(kinds) == 0 {
+			grip.Infof("Found no possible secrets in collection '%s'", target.collection)
+		}
+	}
+	if reported > s.reportLimit {
+		grip.Warningf("%d more possible secrets were found but not reported by path", reported-s.reportLimit)
+	}
+	return nil
+}
+
+// Preflight requires the collections to scan to exist. Scanning doesn't
+// modify any documents.
+func (s *scanSecrets) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	reqs := &PreflightRequirements{}
+	for _, target := range s.targets {
+		reqs.Collections = append(reqs.Collections, target.collection)
+	}
+	return reqs, nil
+}
+
+// scanCollection calls fn with each finding in the paths of the collection's
+// documents, or in the whole documents if there are no paths.
+func (s *scanSecrets) scanCollection(ctx context.Context, coll *mongo.Collection, paths []string, fn func(secretFinding)) error {
+	opts := options.Find().SetSort(bson.M{"_id": 1})
+	if s.batchSize > 0 {
+		opts.SetBatchSize(int32(s.batchSize))
+	}
+	scanID := len(paths) == 0
+	if len(paths) > 0 {
+		projection := bson.M{}
+		for _, path := range paths {
+			projection[path] = 1
+			scanID = scanID || path == "_id"
+		}
+		opts.SetProjection(projection)
+	}
+
+	cur, err := coll.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return errors.Wrap(err, "finding documents")
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		id := cur.Current.Lookup("_id")
+		elems, err := cur.Current.Elements()
+		if err != nil {
+			return errors.Wrapf(err, "reading document %s", id)
+		}
+		for _, elem := range elems {
+			if elem.Key() == "_id" && !scanID {
+				continue
+			}
+			s.scanValue(elem.Key(), elem.Key(), elem.Value(), func(path, kind string) {
+				fn(secretFinding{collection: coll.Name(), id: id, path: path, kind: kind})
+			})
+		}
+	}
+	return errors.Wrap(cur.Err(), "iterating over documents")
+}
+
+// scanValue calls fn with the path of each value within the value that looks
+// like a secret. The key is the name of the field holding the value, which
+// array elements inherit.
+func (s *scanSecrets) scanValue(path, key string, value bson.RawValue, fn func(path, kind string)) {
+	switch value.Type {
+	case bsontype.EmbeddedDocument, bsontype.Array:
+		var elems []bson.RawElement
+		if value.Type == bsontype.EmbeddedDocument {
+			elems, _ = value.Document().Elements()
+		} else {
+			elems, _ = value.Array().Elements()
+		}
+		for _, elem := range elems {
+			elemKey := elem.Key()
+			if value.Type == bsontype.Array {
+				elemKey = key
+			}
+			s.scanValue(path+"."+elem.Key(), elemKey, elem.Value(), fn)
+		}
+	case bsontype.String:
+		if kind := s.detectSecret(key, value.StringValue()); kind != "" {
+			fn(path, kind)
+		}
+	case bsontype.Binary:
+		_, data := value.Binary()
+		if kind := s.detectSecret(key, string(data)); kind != "" {
+			fn(path, kind)
+		}
+	}
+}
+
+// detectSecret returns the kind of secret the value under the key looks like,
+// or an empty string if it doesn't look like one.
+func (s *scanSecrets) detectSecret(key, value string) string {
+	switch {
+	case pemPrivateKeyPattern.MatchString(value):
+		return secretKindPEMPrivateKey
+	case awsAccessKeyIDPattern.MatchString(value):
+		return secretKindAWSAccessKeyID
+	case s.isSecretKey(key) && len(value) >= s.minLength && !strings.ContainsAny(value, " \t\n") && shannonEntropy(value) >= s.minEntropy:
+		return secretKindHighEntropyValue
+	default:
+		return ""
+	}
+}
+
+// isSecretKey returns whether the field name contains one of the key names.
+func (s *scanSecrets) isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, name := range s.keyNames {
+		if strings.Contains(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// shannonEntropy returns the Shannon entropy of the string's bytes in bits per
+// byte.
+func shannonEntropy(value string) float64 {
+	if value == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(value); i++ {
+		counts[value[i]]++
+	}
+	var entropy float64
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(value))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,169 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// SchemaVersionCollection is the collection in the target database that
+	// records the version of its schema.
+	SchemaVersionCollection = "schema_version"
+
+	schemaVersionID         = "schema_version"
+	schemaVersionIDKey      = "_id"
+	schemaVersionKey        = "version"
+	schemaVersionUpdatedKey = "updated_at"
+)
+
+// SchemaStatus is the version of a database's schema and the versioned
+// migrations that have and haven't been applied to it.
+type SchemaStatus struct {
+	Version int
+	Applied []MigrationInfo
+	Pending []MigrationInfo
+}
+
+// GetSchemaStatus returns the version of the database's schema and the
+// versioned migrations that have and haven't been applied to it.
+func GetSchemaStatus(ctx context.Context, client *mongo.Client, database string) (*SchemaStatus, error) {
+	versioned, err := Registry.Versioned()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting versioned migrations")
+	}
+	version, err := getSchemaVersion(ctx, client.Database(database))
+	if err != nil {
+		return nil, err
+	}
+	if version > len(versioned) {
+		return nil, errors.Errorf("database is at schema version %d, which is newer than the latest migration, version %d", version, len(versioned))
+	}
+
+	return &SchemaStatus{
+		Version: version,
+		Applied: versioned[:version],
+		Pending: versioned[version:],
+	}, nil
+}
+
+// UpOptions are the options for applying versioned migrations.
+type UpOptions struct {
+	Database string
+	// To is the schema version to migrate to. If it's 0, all pending
+	// migrations are applied.
+	To int
+	// Safety.Confirm must be UpConfirmToken for the database and versions if
+	// any of the migrations to apply are destructive. Safety's maximum
+	// applies to each destructive migration.
+	Safety             SafetyOptions
+	IgnoreDependencies bool
+	// Clock is the source of time for the migrations, the run history, and
+	// the schema version. If it's not set, the system time is used.
+	Clock Clock
+}
+
+// UpConfirmToken returns the token that confirms migrating the database's
+// schema between the versions, if any of the migrations in between are
+// destructive.
+func UpConfirmToken(database string, from, to int) string {
+	return fmt.Sprintf("schema@%s:%d->%d", database, from, to)
+}
+
+// Up applies the pending versioned migrations in order, up to and including
+// the target version. The schema version is recorded after each migration
+// succeeds, so if a migration fails, the next Up resumes from it.
+func Up(ctx context.Context, client *mongo.Client, opts UpOptions) error {
+	status, err := GetSchemaStatus(ctx, client, opts.Database)
+	if err != nil {
+		return err
+	}
+
+	to := status.Version + len(status.Pending)
+	if opts.To != 0 {
+		if opts.To < status.Version {
+			return errors.Errorf("database is already at schema version %d, which is newer than version %d", status.Version, opts.To)
+		}
+		if opts.To > to {
+			return errors.Errorf("there is no migration for schema version %d; the latest is version %d", opts.To, to)
+		}
+		to = opts.To
+	}
+	if to == status.Version {
+		grip.Infof("Database '%s' is already at schema version %d", opts.Database, to)
+		return nil
+	}
+
+	pending := status.Pending[:to-status.Version]
+	if expected := UpConfirmToken(opts.Database, status.Version, to); hasDestructiveMigrations(pending) && opts.Safety.Confirm != expected {
+		return errors.Errorf("migrating from schema version %d to %d includes destructive migrations and must be confirmed with '%s'", status.Version, to, expected)
+	}
+
+	grip.Infof("Migrating database '%s' from schema version %d to %d", opts.Database, status.Version, to)
+	clock := opts.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	db := client.Database(opts.Database)
+	for _, info := range pending {
+		grip.Infof("Applying schema version %d with script '%s'", info.Version, info.Name)
+		if err := run(ctx, client, RunOptions{
+			Name: info.Name,
+			Options: MigrationOptions{
+				Database: opts.Database,
+				Clock:    opts.Clock,
+			},
+			// Confirming the versions confirms each of their migrations.
+			Safety: SafetyOptions{
+				Confirm:              ConfirmToken(info.Name, opts.Database),
+				MaxAffectedDocuments: opts.Safety.MaxAffectedDocuments,
+			},
+			IgnoreDependencies: opts.IgnoreDependencies,
+		}); err != nil {
+			return errors.Wrapf(err, "applying schema version %d", info.Version)
+		}
+
+		if _, err := db.Collection(SchemaVersionCollection).UpdateOne(ctx,
+			bson.M{schemaVersionIDKey: schemaVersionID},
+			bson.M{"$set": bson.M{
+				schemaVersionKey:        info.Version,
+				schemaVersionUpdatedKey: clock.Now(),
+			}},
+			options.Update().SetUpsert(true),
+		); err != nil {
+			return errors.Wrapf(err, "recording schema version %d", info.Version)
+		}
+	}
+
+	return nil
+}
+
+func hasDestructiveMigrations(migrations []MigrationInfo) bool {
+	for _, info := range migrations {
+		if info.Risk == RiskDestructive {
+			return true
+		}
+	}
+	return false
+}
+
+// getSchemaVersion returns the version of the database's schema, which is 0 if
+// no versioned migrations have been applied to it.
+func getSchemaVersion(ctx context.Context, db *mongo.Database) (int, error) {
+	var doc struct {
+		Version int `bson:"version"`
+	}
+	err := db.Collection(SchemaVersionCollection).FindOne(ctx, bson.M{schemaVersionIDKey: schemaVersionID}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "finding schema version")
+	}
+	return doc.Version, nil
+}
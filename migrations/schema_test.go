@@ -0,0 +1,175 @@
+package migrations
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// versionedTestMigration records in the database that it was applied.
+type versionedTestMigration struct {
+	database string
+	name     string
+	err      error
+}
+
+// Preflight estimates that the migration affects one document.
+func (v *versionedTestMigration) Preflight(context.Context, *mongo.Client) (*PreflightRequirements, error) {
+	return &PreflightRequirements{EstimatedDocuments: 1}, nil
+}
+
+func (v *versionedTestMigration) Execute(ctx context.Context, client *mongo.Client) error {
+	if v.err != nil {
+		return v.err
+	}
+	_, err := client.Database(v.database).Collection("applied").InsertOne(ctx, bson.M{"name": v.name})
+	return err
+}
+
+// withVersionedTestMigrations replaces the registry for the duration of the
+// test with one holding only the named migrations with the risk level, in
+// version order. If failing is set, that migration fails.
+func withVersionedTestMigrations(t *testing.T, failing *string, risk RiskLevel, names ...string) {
+	original := Registry
+	t.Cleanup(func() {
+		Registry = original
+	})
+
+	Registry = migrationRegistry{}
+	for i, name := range names {
+		Registry.registerMigration(name, func(opts MigrationOptions) (Migration, error) {
+			migration := &versionedTestMigration{database: opts.Database, name: name}
+			if *failing == name {
+				migration.err = assert.AnError
+			}
+			return migration, nil
+		}, withRisk(risk), withVersion(i+1))
+	}
+}
+
+func TestVersioned(t *testing.T) {
+	t.Run("SortedByVersion", func(t *testing.T) {
+		registry := migrationRegistry{}
+		registry.registerMigration("second", nil, withVersion(2))
+		registry.registerMigration("adhoc", nil)
+		registry.registerMigration("first", nil, withVersion(1))
+
+		versioned, err := registry.Versioned()
+		require.NoError(t, err)
+		require.Len(t, versioned, 2)
+		assert.Equal(t, "first", versioned[0].Name)
+		assert.Equal(t, "second", versioned[1].Name)
+	})
+	t.Run("GapInVersions", func(t *testing.T) {
+		registry := migrationRegistry{}
+		registry.registerMigration("first", nil, withVersion(1))
+		registry.registerMigration("third", nil, withVersion(3))
+
+		_, err := registry.Versioned()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "migration 'third' has version 3")
+	})
+	t.Run("RegisteredMigrations", func(t *testing.T) {
+		_, err := Registry.Versioned()
+		assert.NoError(t, err)
+	})
+}
+
+func TestUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(ctx))
+	}()
+	_, err := client.Database(db).Collection("applied").InsertOne(ctx, bson.M{"name": "setup"})
+	require.NoError(t, err)
+
+	failing := "three"
+	withVersionedTestMigrations(t, &failing, RiskWrite, "one", "two", "three")
+
+	applied := func() []string {
+		cur, err := client.Database(db).Collection("applied").Find(ctx, bson.M{"name": bson.M{"$ne": "setup"}})
+		require.NoError(t, err)
+		var docs []struct {
+			Name string `bson:"name"`
+		}
+		require.NoError(t, cur.All(ctx, &docs))
+		names := []string{}
+		for _, doc := range docs {
+			names = append(names, doc.Name)
+		}
+		return names
+	}
+	checkStatus := func(version int, pending ...string) {
+		status, err := GetSchemaStatus(ctx, client, db)
+		require.NoError(t, err)
+		assert.Equal(t, version, status.Version)
+		var names []string
+		for _, info := range status.Pending {
+			names = append(names, info.Name)
+		}
+		assert.Equal(t, pending, names)
+	}
+
+	checkStatus(0, "one", "two", "three")
+
+	require.NoError(t, Up(ctx, client, UpOptions{Database: db, To: 1}))
+	assert.Equal(t, []string{"one"}, applied())
+	checkStatus(1, "two", "three")
+
+	err = Up(ctx, client, UpOptions{Database: db})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "applying schema version 3")
+	assert.Equal(t, []string{"one", "two"}, applied())
+	checkStatus(2, "three")
+
+	failing = ""
+	require.NoError(t, Up(ctx, client, UpOptions{Database: db}))
+	assert.Equal(t, []string{"one", "two", "three"}, applied())
+	checkStatus(3)
+
+	require.NoError(t, Up(ctx, client, UpOptions{Database: db}), "up should be a no-op at the latest version")
+	assert.Equal(t, []string{"one", "two", "three"}, applied())
+
+	err = Up(ctx, client, UpOptions{Database: db, To: 2})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already at schema version 3")
+
+	err = Run(ctx, client, RunOptions{Name: "one", Options: MigrationOptions{Database: db}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be applied with up")
+}
+
+func TestUpConfirmsDestructiveMigrations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, db := newTestClient(ctx, t)
+	defer func() {
+		assert.NoError(t, client.Database(db).Drop(ctx))
+	}()
+	_, err := client.Database(db).Collection("applied").InsertOne(ctx, bson.M{"name": "setup"})
+	require.NoError(t, err)
+
+	failing := ""
+	withVersionedTestMigrations(t, &failing, RiskDestructive, "one", "two")
+
+	err = Up(ctx, client, UpOptions{Database: db, Safety: SafetyOptions{Confirm: ConfirmToken("one", db), MaxAffectedDocuments: 1}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be confirmed with '"+UpConfirmToken(db, 0, 2)+"'")
+
+	err = Up(ctx, client, UpOptions{Database: db, Safety: SafetyOptions{Confirm: UpConfirmToken(db, 0, 2)}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a maximum number of affected documents")
+
+	require.NoError(t, Up(ctx, client, UpOptions{Database: db, Safety: SafetyOptions{Confirm: UpConfirmToken(db, 0, 2), MaxAffectedDocuments: 1}}))
+	status, err := GetSchemaStatus(ctx, client, db)
+	require.NoError(t, err)
+	assert.Equal(t, 2, status.Version)
+}
@@ -0,0 +1,173 @@
+// Package secretstore stores project secrets outside of the database.
+package secretstore
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned when there is no secret with a key.
+var ErrNotFound = errors.New("secret not found")
+
+// Key identifies a project's secret.
+type Key struct {
+	ProjectID string
+	Name      string
+}
+
+func (k Key) String() string {
+	return k.ProjectID + "/" + k.Name
+}
+
+// Secret is a secret's value and the restrictions on who can see it.
+type Secret struct {
+	Value string `json:"value"`
+	// Private secrets are never returned to the UI.
+	Private bool `json:"private,omitempty"`
+	// AdminOnly secrets are only accessible to project admins.
+	AdminOnly bool `json:"admin_only,omitempty"`
+}
+
+// Store is a place to keep secrets.
+type Store interface {
+	// Put stores the secret, replacing any secret with the same key.
+	Put(ctx context.Context, key Key, secret Secret) error
+	// Get returns the secret with the key, or ErrNotFound if there isn't
+	// one.
+	Get(ctx context.Context, key Key) (Secret, error)
+}
+
+// fileStoreEnabled is whether Open accepts file URLs.
+var fileStoreEnabled atomic.Bool
+
+// EnableFileStoreForTesting lets Open return a FileStore for file URLs. A
+// FileStore keeps secrets unencrypted on local disk, so only tests may enable
+// it.
+func EnableFileStoreForTesting() {
+	fileStoreEnabled.Store(true)
+}
+
+// Open returns the store at the URL. There is no production secret store yet,
+// so Open fails unless a test has enabled file URLs, for a FileStore in the
+// URL's path.
+func Open(storeURL string) (Store, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing secret store URL '%s'", storeURL)
+	}
+	switch u.Scheme {
+	case "file":
+		if !fileStoreEnabled.Load() {
+			return nil, errors.New("file secret stores are only for testing")
+		}
+		if u.Path == "" {
+			return nil, errors.Errorf("secret store URL '%s' has no path", storeURL)
+		}
+		return NewFileStore(u.Path), nil
+	default:
+		return nil, errors.Errorf("unsupported secret store scheme '%s'", u.Scheme)
+	}
+}
+
+// FileStore is a Store that keeps each secret in a JSON file, in a directory
+// for its project. It's a stand-in for a real secret store when testing. Each
+// file records its key, so two keys that map to the same file, such as names
+// that differ only in case on a case-insensitive file system, are reported
+// rather than overwriting each other.
+type FileStore struct {
+	dir string
+}
+
+// storedSecret is the contents of a FileStore file.
+type storedSecret struct {
+	ProjectID string `json:"project_id"`
+	Name      string `json:"name"`
+	Secret
+}
+
+// NewFileStore returns a store that keeps secrets in the directory, which is
+// created when the first secret is put.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+// Put writes the secret to its file, replacing the file atomically. It fails if
+// the file holds a secret with a different key.
+func (s *FileStore) Put(ctx context.Context, key Key, secret Secret) error {
+	if _, err := s.read(key); err != nil && err != ErrNotFound {
+		return err
+	}
+	data, err := json.Marshal(storedSecret{ProjectID: key.ProjectID, Name: key.Name, Secret: secret})
+	if err != nil {
+		return errors.Wrap(err, "marshalling secret")
+	}
+
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrapf(err, "creating directory for project '%s'", key.ProjectID)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file")
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "writing secret '%s'", key)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "syncing secret '%s'", key)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "closing secret '%s'", key)
+	}
+	return errors.Wrapf(os.Rename(f.Name(), path), "replacing secret '%s'", key)
+}
+
+// Get reads the secret from its file. It fails if the file holds a secret
+// with a different key.
+func (s *FileStore) Get(ctx context.Context, key Key) (Secret, error) {
+	return s.read(key)
+}
+
+func (s *FileStore) read(key Key) (Secret, error) {
+	data, err := os.ReadFile(s.path(key))
+	if os.IsNotExist(err) {
+		return Secret{}, ErrNotFound
+	}
+	if err != nil {
+		return Secret{}, errors.Wrapf(err, "reading secret '%s'", key)
+	}
+
+	var stored storedSecret
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return Secret{}, errors.Wrapf(err, "parsing secret '%s'", key)
+	}
+	storedKey := Key{ProjectID: stored.ProjectID, Name: stored.Name}
+	if storedKey != key {
+		return Secret{}, errors.Errorf("secret '%s' is stored in the same file as secret '%s'", key, storedKey)
+	}
+	return stored.Secret, nil
+}
+
+func (s *FileStore) path(key Key) string {
+	return filepath.Join(s.dir, escapeFileName(key.ProjectID), escapeFileName(key.Name)+".json")
+}
+
+// escapeFileName escapes the name so it's a single path element that can't
+// refer to a parent directory or be mistaken for a temporary file.
+func escapeFileName(name string) string {
+	escaped := url.PathEscape(name)
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "%2E" + escaped[1:]
+	}
+	return escaped
+}
@@ -0,0 +1,86 @@
+package secretstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := NewFileStore(dir)
+	key := Key{ProjectID: "project1", Name: "a"}
+
+	t.Run("NotFound", func(t *testing.T) {
+		_, err := store.Get(ctx, key)
+		assert.Equal(t, ErrNotFound, err)
+	})
+	t.Run("PutAndGet", func(t *testing.T) {
+		secret := Secret{Value: "1", Private: true, AdminOnly: true}
+		require.NoError(t, store.Put(ctx, key, secret))
+		stored, err := store.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, secret, stored)
+	})
+	t.Run("Replace", func(t *testing.T) {
+		require.NoError(t, store.Put(ctx, key, Secret{Value: "2"}))
+		stored, err := store.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, Secret{Value: "2"}, stored)
+
+		entries, err := os.ReadDir(filepath.Join(dir, "project1"))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "temporary files should be removed")
+	})
+	t.Run("EscapedNames", func(t *testing.T) {
+		for _, key := range []Key{
+			{ProjectID: "..", Name: "a"},
+			{ProjectID: "project1", Name: "../../b"},
+			{ProjectID: "project/1", Name: ".c"},
+		} {
+			require.NoError(t, store.Put(ctx, key, Secret{Value: key.String()}))
+			stored, err := store.Get(ctx, key)
+			require.NoError(t, err)
+			assert.Equal(t, key.String(), stored.Value)
+		}
+		matches, err := filepath.Glob(filepath.Join(filepath.Dir(dir), "*.json"))
+		require.NoError(t, err)
+		assert.Empty(t, matches, "secrets should be kept in the store's directory")
+	})
+	t.Run("Collision", func(t *testing.T) {
+		// Simulate a case-insensitive file system, where both keys map to
+		// the same file.
+		upper := Key{ProjectID: "project2", Name: "TOKEN"}
+		lower := Key{ProjectID: "project2", Name: "token"}
+		require.NoError(t, store.Put(ctx, upper, Secret{Value: "1"}))
+		require.NoError(t, os.Rename(store.path(upper), store.path(lower)))
+
+		_, err := store.Get(ctx, lower)
+		assert.Error(t, err)
+		assert.Error(t, store.Put(ctx, lower, Secret{Value: "2"}))
+		data, err := os.ReadFile(store.path(lower))
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"value":"1"`, "the other key's secret should be kept")
+	})
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Open("file://" + dir)
+	assert.Error(t, err, "file stores should only be available once enabled")
+
+	EnableFileStoreForTesting()
+	store, err := Open("file://" + dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, store.(*FileStore).dir)
+
+	for _, storeURL := range []string{"file://", "vault://secrets", "://"} {
+		_, err := Open(storeURL)
+		assert.Error(t, err, storeURL)
+	}
+}
@@ -0,0 +1,55 @@
+package testdata
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FakeClock is a clock for tests whose time only moves when it's told to.
+// Sleeping advances the clock by the sleep duration and returns immediately.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+
+	// OnSleep, if set, is called with the new time each time Sleep advances
+	// the clock. It can be used to simulate work that happens in the
+	// background while a migration waits, such as the TTL monitor deleting
+	// expired documents.
+	OnSleep func(ctx context.Context, now time.Time) error
+}
+
+// NewFakeClock returns a FakeClock whose current time is now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// Sleep advances the clock by d and calls OnSleep, if it's set.
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	now := c.Advance(d)
+	if c.OnSleep != nil {
+		return c.OnSleep(ctx, now)
+	}
+	return nil
+}
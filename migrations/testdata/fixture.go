@@ -0,0 +1,160 @@
+package testdata
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// fixtureDocsExt is the extension of the files in a fixture directory
+	// that hold the documents for a collection.
+	fixtureDocsExt = ".jsonl"
+	// fixtureIndexesFile is the name of the optional file in a fixture
+	// directory listing the indexes to create.
+	fixtureIndexesFile = "indexes.json"
+	// insertBatchSize is the number of documents inserted at once.
+	insertBatchSize = 1000
+)
+
+// Fixture is a set of collections seeded from a fixture directory.
+type Fixture struct {
+	db          *mongo.Database
+	collections []string
+}
+
+// fixtureIndex is an index to create, as listed in a fixture's indexes file.
+type fixtureIndex struct {
+	Collection string `json:"collection"`
+	// Keys is the index key pattern as extended JSON. It's decoded separately
+	// to preserve the order of the keys.
+	Keys               json.RawMessage `json:"keys"`
+	Name               string          `json:"name,omitempty"`
+	Unique             bool            `json:"unique,omitempty"`
+	Sparse             bool            `json:"sparse,omitempty"`
+	ExpireAfterSeconds *int32          `json:"expireAfterSeconds,omitempty"`
+}
+
+// LoadFixture seeds the database from the fixture directory. Each
+// <collection>.jsonl file in the directory holds the extended JSON documents,
+// one per line, to insert into the collection of that name, e.g. tasks.jsonl
+// and task_annotations.jsonl. The directory may also contain an indexes.json
+// file listing the indexes to create, which may include TTL indexes:
+//
+//	[{"collection": "tasks", "keys": {"create_time": 1}, "expireAfterSeconds": 3600}]
+//
+// The returned fixture drops everything it created on Cleanup. It's returned
+// even if seeding fails partway through so that callers can clean up.
+func LoadFixture(ctx context.Context, dir, db string, client *mongo.Client) (*Fixture, error) {
+	f := &Fixture{db: client.Database(db)}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return f, errors.Wrapf(err, "reading fixture directory '%s'", dir)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != fixtureDocsExt {
+			continue
+		}
+		collection := strings.TrimSuffix(entry.Name(), fixtureDocsExt)
+		f.addCollection(collection)
+		if err := InsertDocs(ctx, filepath.Join(dir, entry.Name()), db, collection, client); err != nil {
+			return f, errors.Wrapf(err, "seeding collection '%s'", collection)
+		}
+	}
+
+	indexes, err := readFixtureIndexes(filepath.Join(dir, fixtureIndexesFile))
+	if err != nil {
+		return f, errors.Wrap(err, "reading fixture indexes")
+	}
+	for _, idx := range indexes {
+		f.addCollection(idx.Collection)
+		if err := f.createIndex(ctx, idx); err != nil {
+			return f, errors.Wrapf(err, "creating index in collection '%s'", idx.Collection)
+		}
+	}
+
+	return f, nil
+}
+
+// Collections returns the names of the collections the fixture created.
+func (f *Fixture) Collections() []string {
+	return f.collections
+}
+
+// Cleanup drops all the collections the fixture created.
+func (f *Fixture) Cleanup(ctx context.Context) error {
+	catcher := grip.NewBasicCatcher()
+	for _, collection := range f.collections {
+		catcher.Wrapf(f.db.Collection(collection).Drop(ctx), "dropping collection '%s'", collection)
+	}
+	return catcher.Resolve()
+}
+
+func (f *Fixture) addCollection(collection string) {
+	for _, existing := range f.collections {
+		if existing == collection {
+			return
+		}
+	}
+	f.collections = append(f.collections, collection)
+	sort.Strings(f.collections)
+}
+
+func (f *Fixture) createIndex(ctx context.Context, idx fixtureIndex) error {
+	var keys bson.D
+	if err := bson.UnmarshalExtJSON(idx.Keys, false, &keys); err != nil {
+		return errors.Wrap(err, "parsing index keys")
+	}
+
+	opts := options.Index()
+	if idx.Name != "" {
+		opts.SetName(idx.Name)
+	}
+	if idx.Unique {
+		opts.SetUnique(true)
+	}
+	if idx.Sparse {
+		opts.SetSparse(true)
+	}
+	if idx.ExpireAfterSeconds != nil {
+		opts.SetExpireAfterSeconds(*idx.ExpireAfterSeconds)
+	}
+
+	_, err := f.db.Collection(idx.Collection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts})
+	return err
+}
+
+func readFixtureIndexes(path string) ([]fixtureIndex, error) {
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading '%s'", path)
+	}
+
+	var indexes []fixtureIndex
+	if err := json.Unmarshal(contents, &indexes); err != nil {
+		return nil, errors.Wrapf(err, "parsing '%s'", path)
+	}
+	for i, idx := range indexes {
+		if idx.Collection == "" {
+			return nil, errors.Errorf("index %d has no collection", i)
+		}
+		if len(idx.Keys) == 0 {
+			return nil, errors.Errorf("index %d has no keys", i)
+		}
+	}
+
+	return indexes, nil
+}
@@ -0,0 +1,228 @@
+package testdata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// goldenDir is the name of the directory within a fixture directory that
+// holds the golden files.
+const goldenDir = "expected"
+
+var update = flag.Bool("update", false, "regenerate the golden files for migration tests")
+
+// CheckGolden compares the contents of each collection in the database against
+// the golden file dir/expected/<collection>.jsonl. Documents are compared
+// without regard to the order of their fields or the order of the documents in
+// the collection, but values must have the same BSON types. Run the tests with
+// -update to regenerate the golden files from the current contents.
+func CheckGolden(ctx context.Context, t *testing.T, db *mongo.Database, dir string) {
+	actual, err := SnapshotDatabase(ctx, db)
+	require.NoError(t, err)
+
+	expectedDir := filepath.Join(dir, goldenDir)
+	if *update {
+		require.NoError(t, writeGoldenFiles(expectedDir, actual))
+		return
+	}
+
+	expected, err := readGoldenFiles(expectedDir)
+	require.NoError(t, err)
+
+	// A missing golden file and a missing collection both mean that no
+	// documents are expected.
+	for _, collection := range unionKeys(expected, actual) {
+		expectedDocs := expected[collection]
+		if expectedDocs == nil {
+			expectedDocs = []string{}
+		}
+		actualDocs := actual[collection]
+		if actualDocs == nil {
+			actualDocs = []string{}
+		}
+		assert.Equal(t, expectedDocs, actualDocs, "contents of collection '%s' do not match golden file", collection)
+	}
+}
+
+// SnapshotDatabase returns the normalized contents of every collection in the
+// database, keyed by collection name. Each collection's documents are sorted
+// and their fields are sorted so that snapshots can be compared regardless of
+// field and document order.
+func SnapshotDatabase(ctx context.Context, db *mongo.Database) (map[string][]string, error) {
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": bson.M{"$not": bson.M{"$regex": "^system\\."}}})
+	if err != nil {
+		return nil, errors.Wrap(err, "listing collections")
+	}
+
+	snapshot := make(map[string][]string, len(names))
+	for _, name := range names {
+		cur, err := db.Collection(name).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, errors.Wrapf(err, "finding documents in collection '%s'", name)
+		}
+
+		docs := []string{}
+		for cur.Next(ctx) {
+			doc, err := normalizeDoc(cur.Current)
+			if err != nil {
+				return nil, errors.Wrapf(err, "normalizing document in collection '%s'", name)
+			}
+			docs = append(docs, doc)
+		}
+		if err := cur.Err(); err != nil {
+			return nil, errors.Wrapf(err, "iterating over documents in collection '%s'", name)
+		}
+		if err := cur.Close(ctx); err != nil {
+			return nil, errors.Wrapf(err, "closing cursor for collection '%s'", name)
+		}
+
+		sort.Strings(docs)
+		snapshot[name] = docs
+	}
+
+	return snapshot, nil
+}
+
+// normalizeDoc returns the document as canonical extended JSON with its fields
+// sorted at every level. Canonical extended JSON keeps the type of every
+// value, so an int32 that becomes an int64 or a double shows up as a change.
+func normalizeDoc(doc bson.Raw) (string, error) {
+	extJSON, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return "", errors.Wrap(err, "marshalling document to extended JSON")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(extJSON))
+	// Keep numbers as they were written rather than converting to floats.
+	decoder.UseNumber()
+	var fields interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return "", errors.Wrap(err, "decoding extended JSON")
+	}
+
+	// Maps are marshalled with their keys sorted.
+	sorted, err := json.Marshal(fields)
+	if err != nil {
+		return "", errors.Wrap(err, "marshalling sorted JSON")
+	}
+	return string(sorted), nil
+}
+
+func readGoldenFiles(dir string) (map[string][]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading golden file directory '%s'", dir)
+	}
+
+	golden := map[string][]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != fixtureDocsExt {
+			continue
+		}
+		collection := strings.TrimSuffix(entry.Name(), fixtureDocsExt)
+		docs, err := readGoldenFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading golden file for collection '%s'", collection)
+		}
+		golden[collection] = docs
+	}
+
+	return golden, nil
+}
+
+// readGoldenFile reads the normalized documents in the golden file. The file
+// may be written in relaxed extended JSON, where a bare integer is an int32 (or
+// an int64 if it doesn't fit) and a number with a decimal point is a double.
+func readGoldenFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening path")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	// Set the max buffer size to the max size of a Mongo document (16MB).
+	scanner.Buffer(make([]byte, 4096), 16*1024*1024)
+	docs := []string{}
+	var count int
+	for scanner.Scan() {
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(scanner.Bytes(), false, &doc); err != nil {
+			return nil, errors.Wrapf(err, "unmarshaling golden file line %d", count)
+		}
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshalling golden file line %d", count)
+		}
+		normalized, err := normalizeDoc(raw)
+		if err != nil {
+			return nil, errors.Wrapf(err, "normalizing golden file line %d", count)
+		}
+		docs = append(docs, normalized)
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "reading golden file")
+	}
+
+	sort.Strings(docs)
+	return docs, nil
+}
+
+// writeGoldenFiles replaces the golden files in the directory with the
+// snapshot.
+func writeGoldenFiles(dir string, snapshot map[string][]string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrapf(err, "removing old golden file directory '%s'", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "creating golden file directory '%s'", dir)
+	}
+
+	for collection, docs := range snapshot {
+		var contents strings.Builder
+		for _, doc := range docs {
+			contents.WriteString(doc)
+			contents.WriteString("\n")
+		}
+		path := filepath.Join(dir, collection+fixtureDocsExt)
+		if err := os.WriteFile(path, []byte(contents.String()), 0644); err != nil {
+			return errors.Wrapf(err, "writing golden file '%s'", path)
+		}
+	}
+
+	return nil
+}
+
+// unionKeys returns the sorted keys that are in either map.
+func unionKeys(a, b map[string][]string) []string {
+	keySet := map[string]bool{}
+	for key := range a {
+		keySet[key] = true
+	}
+	for key := range b {
+		keySet[key] = true
+	}
+
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
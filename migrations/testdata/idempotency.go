@@ -0,0 +1,171 @@
+package testdata
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RestartTestSeedEnvVar optionally sets the random seed used to choose where
+// to interrupt migrations, to reproduce a failure.
+const RestartTestSeedEnvVar = "RESTART_TEST_SEED"
+
+// RunFunc runs a migration against the client.
+type RunFunc func(context.Context, *mongo.Client) error
+
+// RestartTest checks that a migration behaves correctly when it's run more
+// than once, as happens when a migration is interrupted and restarted.
+//
+// Each scenario seeds the database from the fixture in Dir and then calls
+// Setup, which returns the function that runs the migration. Every run in a
+// scenario uses the same function, so state that outlives a single process,
+// such as the time on a fake clock, carries over from one run to the next.
+type RestartTest struct {
+	// Dir is the fixture directory.
+	Dir string
+	// Database is the database to seed and run the migration against. It's
+	// dropped after each scenario.
+	Database string
+	// Setup returns the function to run the migration for a scenario.
+	Setup func(t *testing.T, client *mongo.Client) RunFunc
+}
+
+// CheckIdempotent runs the migration twice and checks that the second run
+// doesn't change anything.
+func CheckIdempotent(ctx context.Context, t *testing.T, client *mongo.Client, test RestartTest) {
+	db := client.Database(test.Database)
+	run := test.startScenario(ctx, t, client)
+
+	require.NoError(t, run(ctx, client), "running migration for the first time")
+	first, err := SnapshotDatabase(ctx, db)
+	require.NoError(t, err)
+
+	require.NoError(t, run(ctx, client), "running migration for the second time")
+	second, err := SnapshotDatabase(ctx, db)
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second, "running the migration again should not modify the database")
+}
+
+// CheckInterruptible interrupts the migration by cancelling its context at
+// randomly chosen database commands, restarts it and checks that the final
+// state of the database matches that of a run that wasn't interrupted. The
+// migration is interrupted the given number of times, each in a separate
+// scenario. The random seed is logged so that failures can be reproduced by
+// setting RESTART_TEST_SEED.
+func CheckInterruptible(ctx context.Context, t *testing.T, uri string, test RestartTest, interruptions int) {
+	interrupter := &commandInterrupter{}
+	monitoredClient, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(&event.CommandMonitor{
+		Started: interrupter.commandStarted,
+	}))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, monitoredClient.Disconnect(context.Background()))
+	}()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, client.Disconnect(context.Background()))
+	}()
+	db := client.Database(test.Database)
+
+	run := test.startScenario(ctx, t, client)
+	interrupter.reset(ctx, 0)
+	require.NoError(t, run(ctx, monitoredClient), "running migration without interruption")
+	numCommands := interrupter.count()
+	expected, err := SnapshotDatabase(ctx, db)
+	require.NoError(t, err)
+	if numCommands == 0 {
+		t.Log("migration ran no commands, so it can't be interrupted")
+		return
+	}
+
+	seed := time.Now().UnixNano()
+	if seedStr := os.Getenv(RestartTestSeedEnvVar); seedStr != "" {
+		seed, err = strconv.ParseInt(seedStr, 10, 64)
+		require.NoError(t, err, "parsing seed '%s' from env var '%s'", seedStr, RestartTestSeedEnvVar)
+	}
+	t.Logf("interrupting migration at random commands out of %d using seed %d", numCommands, seed)
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < interruptions; i++ {
+		interruptAt := rng.Intn(numCommands) + 1
+
+		run := test.startScenario(ctx, t, client)
+		runCtx := interrupter.reset(ctx, interruptAt)
+		err := run(runCtx, monitoredClient)
+		t.Logf("interrupted migration at command %d: %v", interruptAt, err)
+		interrupter.reset(ctx, 0)
+
+		require.NoError(t, run(ctx, client), "restarting migration after interrupting it at command %d", interruptAt)
+		actual, err := SnapshotDatabase(ctx, db)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual, "final state after interrupting migration at command %d does not match an uninterrupted run", interruptAt)
+	}
+}
+
+// startScenario drops the database, seeds it from the fixture and returns the
+// function that runs the migration.
+func (test RestartTest) startScenario(ctx context.Context, t *testing.T, client *mongo.Client) RunFunc {
+	db := client.Database(test.Database)
+	require.NoError(t, db.Drop(ctx))
+	t.Cleanup(func() {
+		assert.NoError(t, db.Drop(context.Background()))
+	})
+
+	_, err := LoadFixture(ctx, test.Dir, test.Database, client)
+	require.NoError(t, err, "loading fixture")
+
+	return test.Setup(t, client)
+}
+
+// commandInterrupter counts the commands sent by a client and cancels a
+// context when the chosen command starts.
+type commandInterrupter struct {
+	mu          sync.Mutex
+	numCommands int
+	interruptAt int
+	cancel      context.CancelFunc
+}
+
+// reset restarts the count and returns a context that's cancelled when the
+// interruptAt-th command starts. If interruptAt is zero, the context is never
+// cancelled by the interrupter.
+func (i *commandInterrupter) reset(ctx context.Context, interruptAt int) context.Context {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.cancel != nil {
+		i.cancel()
+	}
+	ctx, i.cancel = context.WithCancel(ctx)
+	i.numCommands = 0
+	i.interruptAt = interruptAt
+	return ctx
+}
+
+func (i *commandInterrupter) count() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.numCommands
+}
+
+func (i *commandInterrupter) commandStarted(_ context.Context, _ *event.CommandStartedEvent) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.numCommands++
+	if i.numCommands == i.interruptAt && i.cancel != nil {
+		i.cancel()
+	}
+}
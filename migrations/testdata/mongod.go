@@ -0,0 +1,236 @@
+package testdata
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+const (
+	mongodBinary = "mongod"
+	// replicaSetName is the name of the replica set when the server is
+	// started as a single-node replica set.
+	replicaSetName = "rs0"
+
+	serverStartTimeout = time.Minute
+	serverStopTimeout  = 30 * time.Second
+	serverPollInterval = 100 * time.Millisecond
+
+	// maxDatabaseNameLength is kept below the server's limit on database
+	// name length to leave room for the unique suffix.
+	maxDatabaseNameLength = 50
+)
+
+// ErrMongodNotFound is returned when there is no mongod binary on the PATH.
+var ErrMongodNotFound = errors.New("mongod binary not found on PATH")
+
+// ServerOptions configure a throwaway mongod.
+type ServerOptions struct {
+	// ReplicaSet starts the server as a single-node replica set, which is
+	// required for transactions and change streams.
+	ReplicaSet bool
+}
+
+// Server is a throwaway mongod for tests. Its data lives in a temporary
+// directory that's removed when the server is stopped.
+type Server struct {
+	// URI is the connection string for the server.
+	URI string
+
+	host string
+	cmd  *exec.Cmd
+	dir  string
+	done chan error
+}
+
+// StartServer starts a mongod from the binary on the PATH, listening on a free
+// local port, and waits until it's ready to accept writes. If there is no
+// mongod on the PATH, it returns ErrMongodNotFound so that callers can skip
+// tests that need a database.
+func StartServer(opts ServerOptions) (*Server, error) {
+	binary, err := exec.LookPath(mongodBinary)
+	if err != nil {
+		return nil, ErrMongodNotFound
+	}
+
+	dir, err := os.MkdirTemp("", "migrations-mongod-")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating data directory")
+	}
+
+	port, err := freePort()
+	if err != nil {
+		grip.Warning(os.RemoveAll(dir))
+		return nil, errors.Wrap(err, "finding free port")
+	}
+
+	args := []string{
+		"--dbpath", dir,
+		"--port", strconv.Itoa(port),
+		"--bind_ip", "127.0.0.1",
+		"--logpath", filepath.Join(dir, "mongod.log"),
+	}
+	host := fmt.Sprintf("127.0.0.1:%d", port)
+	if opts.ReplicaSet {
+		args = append(args, "--replSet", replicaSetName)
+	}
+
+	s := &Server{
+		URI:  fmt.Sprintf("mongodb://%s/?directConnection=true", host),
+		host: host,
+		cmd:  exec.Command(binary, args...),
+		dir:  dir,
+		done: make(chan error, 1),
+	}
+	if err := s.cmd.Start(); err != nil {
+		grip.Warning(os.RemoveAll(dir))
+		return nil, errors.Wrap(err, "starting mongod")
+	}
+	go func() {
+		s.done <- s.cmd.Wait()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), serverStartTimeout)
+	defer cancel()
+	if err := s.waitUntilReady(ctx, opts.ReplicaSet); err != nil {
+		catcher := grip.NewBasicCatcher()
+		catcher.Add(err)
+		catcher.Wrap(s.Stop(), "stopping mongod")
+		return nil, errors.Wrapf(catcher.Resolve(), "waiting for mongod to start (see log in '%s')", dir)
+	}
+
+	return s, nil
+}
+
+// Stop shuts down the server and removes its data directory.
+func (s *Server) Stop() error {
+	catcher := grip.NewBasicCatcher()
+	select {
+	case <-s.done:
+	default:
+		catcher.Wrap(s.cmd.Process.Signal(syscall.SIGTERM), "signalling mongod to shut down")
+		select {
+		case <-s.done:
+		case <-time.After(serverStopTimeout):
+			catcher.Wrap(s.cmd.Process.Kill(), "killing mongod")
+			<-s.done
+		}
+	}
+	catcher.Wrap(os.RemoveAll(s.dir), "removing data directory")
+
+	return catcher.Resolve()
+}
+
+// waitUntilReady waits until the server accepts connections and, if it's a
+// replica set, initiates the replica set and waits for it to elect a primary.
+func (s *Server) waitUntilReady(ctx context.Context, replicaSet bool) error {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(s.URI))
+	if err != nil {
+		return errors.Wrap(err, "connecting to mongod")
+	}
+	defer func() {
+		grip.Warning(client.Disconnect(context.Background()))
+	}()
+
+	if err := s.poll(ctx, func() error {
+		return client.Ping(ctx, readpref.PrimaryPreferred())
+	}); err != nil {
+		return errors.Wrap(err, "pinging mongod")
+	}
+	if !replicaSet {
+		return nil
+	}
+
+	// Name the member by the address the server is listening on, since the
+	// default is the machine's hostname, which may not resolve to it.
+	config := bson.M{
+		"_id":     replicaSetName,
+		"members": bson.A{bson.M{"_id": 0, "host": s.host}},
+	}
+	if err := client.Database("admin").RunCommand(ctx, bson.M{"replSetInitiate": config}).Err(); err != nil {
+		return errors.Wrap(err, "initiating replica set")
+	}
+
+	return errors.Wrap(s.poll(ctx, func() error {
+		var res struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+		}
+		if err := client.Database("admin").RunCommand(ctx, bson.M{"hello": 1}).Decode(&res); err != nil {
+			return err
+		}
+		if !res.IsWritablePrimary {
+			return errors.New("replica set has not elected a primary")
+		}
+		return nil
+	}), "waiting for replica set primary")
+}
+
+// poll calls check until it succeeds, mongod exits or the context is done.
+func (s *Server) poll(ctx context.Context, check func() error) error {
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case exitErr := <-s.done:
+			s.done <- exitErr
+			if exitErr == nil {
+				return errors.New("mongod exited")
+			}
+			return errors.Wrap(exitErr, "mongod exited")
+		case <-ctx.Done():
+			return errors.Wrapf(err, "timed out: %s", ctx.Err())
+		case <-time.After(serverPollInterval):
+		}
+	}
+}
+
+// freePort returns a local port that is not currently in use.
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+var invalidDatabaseNameChars = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// UniqueDatabaseName returns a database name derived from the test's name that
+// is unique to this run of the test, so that tests don't interfere with each
+// other when they share a server.
+func UniqueDatabaseName(t testing.TB) string {
+	name := strings.Trim(invalidDatabaseNameChars.ReplaceAllString(t.Name(), "_"), "_")
+	if len(name) > maxDatabaseNameLength {
+		name = name[:maxDatabaseNameLength]
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatalf("generating database name suffix: %s", err)
+	}
+
+	return fmt.Sprintf("%s_%s", name, hex.EncodeToString(suffix))
+}
@@ -1,15 +1,17 @@
 package testdata
 
 import (
-	"bufio"
 	"context"
 	"os"
 
+	"github.com/evergreen-ci/evergreen-migrations/migrations/archive"
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// InsertDocs inserts the extended JSON documents in the JSONL file at path into
+// the collection.
 func InsertDocs(ctx context.Context, path, db, collection string, client *mongo.Client) error {
 	file, err := os.Open(path)
 	if err != nil {
@@ -17,23 +19,29 @@ func InsertDocs(ctx context.Context, path, db, collection string, client *mongo.
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	// Set the max buffer size to the max size of a Mongo document (16MB).
-	scanner.Buffer(make([]byte, 4096), 16*1024*1024)
+	coll := client.Database(db).Collection(collection)
 	var count int
-	for scanner.Scan() {
-		bytes := scanner.Bytes()
+	batch := make([]interface{}, 0, insertBatchSize)
+	if err := archive.Decode(file, archive.FormatJSONL, func(doc bson.Raw) error {
+		batch = append(batch, doc)
+		count++
 
-		var doc bson.D
-		if err := bson.UnmarshalExtJSON(bytes, false, &doc); err != nil {
-			return errors.Wrapf(err, "unmarshaling test data line %d", count)
-		}
-		if _, err = client.Database(db).Collection(collection).InsertOne(ctx, doc); err != nil {
-			return errors.Wrapf(err, "inserting test data line %d", count)
+		if len(batch) == insertBatchSize {
+			if _, err = coll.InsertMany(ctx, batch); err != nil {
+				return errors.Wrapf(err, "inserting test data through document %d", count-1)
+			}
+			batch = batch[:0]
 		}
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "reading test data")
+	}
 
-		count++
+	if len(batch) > 0 {
+		if _, err = coll.InsertMany(ctx, batch); err != nil {
+			return errors.Wrapf(err, "inserting test data through document %d", count-1)
+		}
 	}
 
-	return scanner.Err()
+	return nil
 }
@@ -0,0 +1,97 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/evergreen-ci/evergreen-migrations/migrations/secretstore"
+	"github.com/evergreen-ci/evergreen-migrations/migrations/testdata"
+	"github.com/mongodb/grip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testServer is the throwaway mongod shared by the package's tests. It's nil
+// if there is no mongod binary on the PATH.
+var testServer *testdata.Server
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	secretstore.EnableFileStoreForTesting()
+
+	server, err := testdata.StartServer(testdata.ServerOptions{ReplicaSet: true})
+	if err != nil && err != testdata.ErrMongodNotFound {
+		fmt.Fprintf(os.Stderr, "starting test mongod: %s\n", err)
+		return 1
+	}
+	if server != nil {
+		testServer = server
+		defer func() {
+			grip.Warning(server.Stop())
+		}()
+	}
+
+	return m.Run()
+}
+
+// newTestClient returns a client connected to the test server and a database
+// name that is unique to the test. It skips the test if there is no mongod to
+// test against.
+func newTestClient(ctx context.Context, t *testing.T) (*mongo.Client, string) {
+	if testServer == nil {
+		t.Skip("skipping test that requires a database: no mongod binary on PATH")
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(testServer.URI))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, client.Disconnect(context.Background()))
+	})
+
+	return client, testdata.UniqueDatabaseName(t)
+}
+
+// runGoldenTest builds the registered migration through the registry, runs it
+// against the fixture in testdata/<name>/<testCase> and compares the resulting
+// collections against the fixture's golden files. It returns the database so
+// that callers can make further checks; it's dropped when the test finishes.
+func runGoldenTest(t *testing.T, name, testCase string, opts MigrationOptions) *mongo.Database {
+	return runGoldenTestAfter(t, name, testCase, opts, nil)
+}
+
+// runGoldenTestAfter is like runGoldenTest, but runs setup against the seeded
+// database before the migration is built.
+func runGoldenTestAfter(t *testing.T, name, testCase string, opts MigrationOptions, setup func(context.Context, *mongo.Database)) *mongo.Database {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, dbName := newTestClient(ctx, t)
+	db := client.Database(dbName)
+	t.Cleanup(func() {
+		assert.NoError(t, db.Drop(context.Background()))
+	})
+
+	dir := path.Join("testdata", name, testCase)
+	_, err := testdata.LoadFixture(ctx, dir, dbName, client)
+	require.NoError(t, err, "loading fixture")
+
+	if setup != nil {
+		setup(ctx, db)
+	}
+
+	opts.Database = dbName
+	migration, err := Registry.Migration(name, opts)
+	require.NoError(t, err)
+	require.NoError(t, migration.Execute(ctx, client), "running migration")
+
+	testdata.CheckGolden(ctx, t, db, dir)
+	return db
+}
@@ -23,7 +23,7 @@ const (
 )
 
 func init() {
-	Registry.registerMigration(ttlMigrationName, NewTTLCollection)
+	Registry.registerMigration(ttlMigrationName, NewTTLCollection, withRisk(RiskDestructive))
 }
 
 type TTLCollection struct {
@@ -33,6 +33,7 @@ type TTLCollection struct {
 	goalTTL      time.Duration
 	ttlDecrement time.Duration
 	ttlField     string
+	clock        Clock
 }
 
 func NewTTLCollection(opts MigrationOptions) (Migration, error) {
@@ -78,13 +79,14 @@ func NewTTLCollection(opts MigrationOptions) (Migration, error) {
 		goalTTL:      goalTTL,
 		ttlDecrement: ttlDecrement,
 		ttlField:     ttlField,
+		clock:        opts.getClock(),
 	}, catcher.Resolve()
 }
 
 func (t *TTLCollection) Execute(ctx context.Context, client *mongo.Client) error {
 	for {
 		// Capture the time at the beginning of this iteration so we aren't working against a moving target.
-		now := time.Now()
+		now := t.clock.Now()
 
 		nextTTL, err := t.getNextTTL(ctx, now, client)
 		if err != nil {
@@ -154,6 +156,26 @@ func (t *TTLCollection) waitForTTL(ctx context.Context, now time.Time, ttl time.
 			}
 			return errors.Wrap(err, "checking for remaining documents to TTL")
 		}
-		time.Sleep(ttlWaitSleep)
+		if err := t.clock.Sleep(ctx, ttlWaitSleep); err != nil {
+			return errors.Wrap(err, "waiting for TTL job to delete documents")
+		}
+	}
+}
+
+// Preflight requires an index on the TTL field, since the migration sets the
+// TTL by modifying that index, and estimates the number of documents that will
+// have expired once the goal TTL is reached.
+func (t *TTLCollection) Preflight(ctx context.Context, client *mongo.Client) (*PreflightRequirements, error) {
+	count, err := client.Database(t.database).Collection(t.collection).CountDocuments(ctx, bson.M{
+		t.ttlField: bson.M{"$lt": t.clock.Now().Add(-t.goalTTL)},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "counting documents older than the goal TTL")
 	}
+
+	return &PreflightRequirements{
+		Collections:        []string{t.collection},
+		Indexes:            []RequiredIndex{{Collection: t.collection, Fields: []string{t.ttlField}}},
+		EstimatedDocuments: count,
+	}, nil
 }
@@ -8,20 +8,18 @@ import (
 	"time"
 
 	"github.com/evergreen-ci/evergreen-migrations/migrations/testdata"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func TestGetNextTTL(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	client, err := mongo.Connect(ctx)
-	require.NoError(t, err)
-	db := "migrations_test"
+	client, db := newTestClient(ctx, t)
 	collection := "tasks"
 	ttlField := "create_time"
 	require.NoError(t, testdata.InsertDocs(ctx, path.Join("testdata", "ttlCollection", "tasks.jsonl"), db, collection, client))
@@ -80,44 +78,91 @@ func TestGetNextTTL(t *testing.T) {
 	}
 }
 
-// TestExecute is an e2e test of the migration. It runs approximately two minutes.
-// You can follow along its progress in a mongo shell by running db.tasks.getIndexes()
-// to see the TTL on the create_time field and db.tasks.find() to see the remaining tasks.
+// TestExecute is an e2e test of the migration. A fake clock stands in for the
+// TTL monitor: each time the migration sleeps, documents that have expired
+// under the collection's current TTL are deleted.
 func TestExecute(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	client, err := mongo.Connect(ctx)
-	require.NoError(t, err)
-	db := "migrations_test"
+	client, db := newTestClient(ctx, t)
 	collection := "tasks"
 	ttlField := "create_time"
-	require.NoError(t, testdata.InsertDocs(ctx, path.Join("testdata", "ttlCollection", "tasks.jsonl"), db, collection, client))
+	fixture, err := testdata.LoadFixture(ctx, path.Join("testdata", "ttlCollection", "execute"), db, client)
 	defer func() {
-		require.NoError(t, client.Database(db).Drop(ctx))
+		require.NoError(t, fixture.Cleanup(ctx))
 	}()
-
-	now := time.Now().UTC()
-	_, err = client.Database(db).Collection(collection).InsertOne(ctx, bson.M{ttlField: now})
 	require.NoError(t, err)
-	_, err = client.Database(db).Collection(collection).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: ttlField, Value: 1}}})
+
+	coll := client.Database(db).Collection(collection)
+
+	start := time.Date(2100, 01, 05, 0, 0, 0, 0, time.UTC)
+	clock := testdata.NewFakeClock(start)
+	var ttls []time.Duration
+	clock.OnSleep = func(ctx context.Context, now time.Time) error {
+		ttl, err := getIndexTTL(ctx, coll, ttlField)
+		if err != nil {
+			return err
+		}
+		if len(ttls) == 0 || ttls[len(ttls)-1] != ttl {
+			ttls = append(ttls, ttl)
+		}
+		_, err = coll.DeleteMany(ctx, bson.M{ttlField: bson.M{"$lt": now.Add(-ttl)}})
+		return err
+	}
+
+	t.Setenv(goalTTLEnvVar, "24h")
+	t.Setenv(ttlDecrementEnvVar, "24h")
+	t.Setenv(ttlFieldEnvVar, ttlField)
+	ttlJob, err := Registry.Migration(ttlMigrationName, MigrationOptions{
+		Database:   db,
+		Collection: collection,
+		BatchSize:  1,
+		Clock:      clock,
+	})
 	require.NoError(t, err)
 
-	ttlJob := TTLCollection{
-		database:     db,
-		collection:   collection,
-		batchSize:    3,
-		ttlDecrement: 24 * time.Hour,
-		ttlField:     ttlField,
-		goalTTL:      24 * time.Hour,
+	require.NoError(t, ttlJob.Execute(ctx, client))
+
+	require.NotEmpty(t, ttls)
+	for i := 1; i < len(ttls); i++ {
+		assert.Less(t, ttls[i], ttls[i-1], "TTL should decrease with each step")
 	}
+	finalTTL, err := getIndexTTL(ctx, coll, ttlField)
+	require.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, finalTTL)
 
-	assert.NoError(t, ttlJob.Execute(ctx, client))
+	count, err := coll.CountDocuments(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
 	task := struct {
 		CreateTime time.Time `bson:"create_time"`
 	}{}
-	assert.NoError(t, client.Database(db).Collection(collection).FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.M{ttlField: 1})).Decode(&task))
-	assert.True(t, task.CreateTime.Equal(now.Truncate(time.Millisecond)))
+	require.NoError(t, coll.FindOne(ctx, bson.M{}).Decode(&task))
+	assert.True(t, task.CreateTime.Equal(start))
+}
+
+// getIndexTTL returns the TTL of the index on the field.
+func getIndexTTL(ctx context.Context, coll *mongo.Collection, field string) (time.Duration, error) {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	for cur.Next(ctx) {
+		keys, ok := cur.Current.Lookup("key").DocumentOK()
+		if !ok || keys.Lookup(field).IsZero() {
+			continue
+		}
+		seconds, ok := cur.Current.Lookup("expireAfterSeconds").AsInt64OK()
+		if !ok {
+			return 0, errors.Errorf("index on field '%s' has no TTL", field)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	return 0, errors.Errorf("no index on field '%s'", field)
 }
 
 func TestNewTTLCollection(t *testing.T) {
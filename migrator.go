@@ -15,12 +15,20 @@ import (
 )
 
 const (
-	urlFlag        = "url"
-	dbFlag         = "db"
-	scriptFlag     = "script"
-	collectionFlag = "collection"
-	batchSizeFlag  = "batch-size"
-	skipDBAuthFlag = "skip-db-auth"
+	urlFlag         = "url"
+	dbFlag          = "db"
+	scriptFlag      = "script"
+	collectionFlag  = "collection"
+	batchSizeFlag   = "batch-size"
+	skipDBAuthFlag  = "skip-db-auth"
+	confirmFlag     = "confirm"
+	maxAffectedFlag = "max-affected"
+	planFlag        = "plan"
+	ignoreDepsFlag  = "ignore-deps"
+	toFlag          = "to"
+
+	confirmEnvVar     = "MIGRATION_CONFIRM"
+	maxAffectedEnvVar = "MIGRATION_MAX_AFFECTED"
 
 	awsAuthMechanism        = "MONGODB-AWS"
 	mongoExternalAuthSource = "$external"
@@ -43,9 +51,8 @@ func main() {
 			Required: true,
 		},
 		cli.StringFlag{
-			Name:     scriptFlag,
-			Usage:    "Name of the script to run",
-			Required: true,
+			Name:  scriptFlag,
+			Usage: "Name of the script to run",
 		},
 		cli.StringFlag{
 			Name:  collectionFlag,
@@ -59,41 +66,165 @@ func main() {
 			Name:  skipDBAuthFlag,
 			Usage: "Connect to the database without authorization, for local testing",
 		},
+		cli.StringFlag{
+			Name:   confirmFlag,
+			Usage:  "Confirm running a destructive script with '<script>@<db>', a plan with '<plan>@<db>', or an up with 'schema@<db>:<from>-><to>'",
+			EnvVar: confirmEnvVar,
+		},
+		cli.Int64Flag{
+			Name:   maxAffectedFlag,
+			Usage:  "Abort a destructive script if it's estimated to affect more than this many documents",
+			EnvVar: maxAffectedEnvVar,
+		},
+		cli.BoolFlag{
+			Name:  ignoreDepsFlag,
+			Usage: "Run the script even if its prerequisites haven't succeeded against the database",
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "check",
+			Usage: "Run only the preflight checks for the script",
+			Action: func(c *cli.Context) error {
+				script, err := requiredScript(c)
+				if err != nil {
+					return err
+				}
+				return withClient(c, func(ctx context.Context, client *mongo.Client) error {
+					_, err := migrations.Check(ctx, client, script, migrationOptions(c))
+					return err
+				})
+			},
+		},
+		{
+			Name:  "apply",
+			Usage: "Run the steps of a migration plan in order",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:     planFlag,
+					Usage:    "Path to the YAML plan file",
+					Required: true,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				plan, err := migrations.LoadPlan(c.String(planFlag))
+				if err != nil {
+					return errors.Wrap(err, "loading plan")
+				}
+				return withClient(c, func(ctx context.Context, client *mongo.Client) error {
+					return migrations.RunPlan(ctx, client, plan, migrations.PlanOptions{
+						Database:             c.GlobalString(dbFlag),
+						Confirm:              c.GlobalString(confirmFlag),
+						MaxAffectedDocuments: c.GlobalInt64(maxAffectedFlag),
+						IgnoreDependencies:   c.GlobalBool(ignoreDepsFlag),
+					})
+				})
+			},
+		},
+		{
+			Name:  "up",
+			Usage: "Apply the pending versioned migrations in order",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  toFlag,
+					Usage: "Schema version to migrate to (defaults to the latest)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return withClient(c, func(ctx context.Context, client *mongo.Client) error {
+					return migrations.Up(ctx, client, migrations.UpOptions{
+						Database: c.GlobalString(dbFlag),
+						To:       c.Int(toFlag),
+						Safety: migrations.SafetyOptions{
+							Confirm:              c.GlobalString(confirmFlag),
+							MaxAffectedDocuments: c.GlobalInt64(maxAffectedFlag),
+						},
+						IgnoreDependencies: c.GlobalBool(ignoreDepsFlag),
+					})
+				})
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "Show the schema version and the pending versioned migrations",
+			Action: func(c *cli.Context) error {
+				return withClient(c, func(ctx context.Context, client *mongo.Client) error {
+					status, err := migrations.GetSchemaStatus(ctx, client, c.GlobalString(dbFlag))
+					if err != nil {
+						return errors.Wrap(err, "getting schema status")
+					}
+					grip.Infof("Schema version: %d", status.Version)
+					for _, info := range status.Applied {
+						grip.Infof("Applied: %d %s", info.Version, info.Name)
+					}
+					for _, info := range status.Pending {
+						grip.Infof("Pending: %d %s", info.Version, info.Name)
+					}
+					return nil
+				})
+			},
+		},
 	}
 	app.Action = func(c *cli.Context) error {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
-		go func() {
-			exitCh := make(chan os.Signal, 1)
-			signal.Notify(exitCh, syscall.SIGTERM)
-			<-exitCh
-			cancel()
-		}()
-
-		clientOps := options.Client().ApplyURI(c.String(urlFlag))
-		if !c.Bool(skipDBAuthFlag) {
-			clientOps.SetAuth(options.Credential{
-				AuthMechanism: awsAuthMechanism,
-				AuthSource:    mongoExternalAuthSource,
-			})
-		}
-		client, err := mongo.Connect(ctx, clientOps)
+		script, err := requiredScript(c)
 		if err != nil {
-			return errors.Wrap(err, "getting mongo client")
+			return err
 		}
-
-		migration, err := migrations.Registry.Migration(c.String(scriptFlag), migrations.MigrationOptions{
-			Database:   c.String(dbFlag),
-			Collection: c.String(collectionFlag),
-			BatchSize:  c.Int(batchSizeFlag),
+		return withClient(c, func(ctx context.Context, client *mongo.Client) error {
+			return migrations.Run(ctx, client, migrations.RunOptions{
+				Name:               script,
+				Options:            migrationOptions(c),
+				IgnoreDependencies: c.GlobalBool(ignoreDepsFlag),
+				Safety: migrations.SafetyOptions{
+					Confirm:              c.GlobalString(confirmFlag),
+					MaxAffectedDocuments: c.GlobalInt64(maxAffectedFlag),
+				},
+			})
 		})
-		if err != nil {
-			return errors.Wrap(err, "getting migration script")
-		}
-
-		return migration.Execute(ctx, client)
 	}
 
 	grip.EmergencyFatal(app.Run(os.Args))
 }
+
+func requiredScript(c *cli.Context) (string, error) {
+	script := c.GlobalString(scriptFlag)
+	if script == "" {
+		return "", errors.Errorf("required flag '%s' not set", scriptFlag)
+	}
+	return script, nil
+}
+
+func migrationOptions(c *cli.Context) migrations.MigrationOptions {
+	return migrations.MigrationOptions{
+		Database:   c.GlobalString(dbFlag),
+		Collection: c.GlobalString(collectionFlag),
+		BatchSize:  c.GlobalInt(batchSizeFlag),
+	}
+}
+
+// withClient connects to the database and calls fn with the client.
+func withClient(c *cli.Context, fn func(context.Context, *mongo.Client) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		exitCh := make(chan os.Signal, 1)
+		signal.Notify(exitCh, syscall.SIGTERM)
+		<-exitCh
+		cancel()
+	}()
+
+	clientOps := options.Client().ApplyURI(c.GlobalString(urlFlag))
+	if !c.GlobalBool(skipDBAuthFlag) {
+		clientOps.SetAuth(options.Credential{
+			AuthMechanism: awsAuthMechanism,
+			AuthSource:    mongoExternalAuthSource,
+		})
+	}
+	client, err := mongo.Connect(ctx, clientOps)
+	if err != nil {
+		return errors.Wrap(err, "getting mongo client")
+	}
+
+	return fn(ctx, client)
+}